@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uvalib/uva-aws-s3-sdk/uva-s3"
+)
+
+// BackfillResult - the outcome of processing one backfill object
+type BackfillResult struct {
+	Key         string // the object key
+	Err         error  // the processing error (if any)
+	Interrupted bool   // processing was abandoned because we were interrupted
+}
+
+// reprocess every object under a bucket prefix and report the outcome
+func backfillCommand(args []string) int {
+
+	flags := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	bucket := flags.String("bucket", "", "the source bucket name")
+	prefix := flags.String("prefix", "", "the key prefix to enumerate")
+	suffix := flags.String("suffix", "", "only process keys with this suffix (comma separated list)")
+	since := flags.String("since", "", "only process objects modified on or after this date (YYYY-MM-DD or RFC3339)")
+	concurrency := flags.Int("concurrency", 0, "the number of objects to process at once (default IIIF_INGEST_WORKERS)")
+	checkpoint := flags.String("checkpoint", "", "the checkpoint file used to resume an interrupted backfill")
+	err := flags.Parse(args)
+	if err != nil {
+		return exitUsage
+	}
+
+	if len(*bucket) == 0 {
+		fmt.Fprintf(os.Stderr, "backfill: -bucket is required\n")
+		flags.Usage()
+		return exitUsage
+	}
+
+	var modifiedSince time.Time
+	if len(*since) != 0 {
+		modifiedSince, err = parseSinceDate(*since)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "backfill: invalid -since date (%s)\n", err.Error())
+			return exitUsage
+		}
+	}
+
+	// the inbound source is specified on the command line
+	cfg := LoadConfiguration(false)
+
+	// we are reprocessing existing sources so never remove them
+	if cfg.DeleteSource == true {
+		log.Printf("[main] WARNING: ignoring IIIF_INGEST_DELETE_SOURCE during backfill")
+		cfg.DeleteSource = false
+	}
+
+	workers := *concurrency
+	if workers <= 0 {
+		workers = cfg.Workers
+	}
+
+	// load the keys already processed by an earlier run
+	completed, err := loadCheckpoint(*checkpoint)
+	if err != nil {
+		return exitFailure
+	}
+
+	// locate everything to be processed
+	objects, err := listBucketObjects(*bucket, *prefix)
+	if err != nil {
+		return exitFailure
+	}
+	selected, skipped := filterBackfillObjects(objects, strings.Split(*suffix, ","), modifiedSince, completed)
+
+	// when grouping, the page objects are processed as part of their group
+	if len(cfg.GroupMarker) != 0 {
+		markers := make([]BucketObject, 0, len(selected))
+		for _, o := range selected {
+			if isGroupMarker(*cfg, o.Key) == true {
+				markers = append(markers, o)
+			} else {
+				skipped++
+			}
+		}
+		selected = markers
+	}
+	log.Printf("[main] INFO: backfilling %d object(s) (%d skipped) with %d worker(s)", len(selected), skipped, workers)
+
+	s3Svc, err := uva_s3.NewUvaS3(uva_s3.UvaS3Config{Logging: true})
+	fatalIfError(err)
+
+	// the checkpoint file is appended to as each object completes successfully
+	var checkpointFile *os.File
+	if len(*checkpoint) != 0 {
+		checkpointFile, err = os.OpenFile(*checkpoint, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Printf("[main] ERROR: opening checkpoint file %s (%s)", *checkpoint, err.Error())
+			return exitFailure
+		}
+		defer checkpointFile.Close()
+	}
+
+	// an interrupt abandons the in-flight jobs, the checkpoint allows us to resume later
+	ctx, stop := newShutdownContext()
+	defer stop()
+
+	start := time.Now()
+	objectChan := make(chan BucketObject)
+	resultChan := make(chan BackfillResult)
+
+	var wg sync.WaitGroup
+	for w := 1; w <= workers; w++ {
+		wg.Add(1)
+		go func(workerId int) {
+			defer wg.Done()
+			for o := range objectChan {
+				if ctx.Err() != nil {
+					resultChan <- BackfillResult{Key: o.Key, Interrupted: true}
+					continue
+				}
+				notify := Notify{SourceBucket: *bucket, BucketKey: o.Key, ExpectedSize: o.Size, ETag: o.ETag}
+				_, err := processWithRetries(ctx, ctx, workerId, *cfg, s3Svc, notify)
+				// a job that fails because we were interrupted has not really been attempted
+				resultChan <- BackfillResult{Key: o.Key, Err: err, Interrupted: err != nil && ctx.Err() != nil}
+			}
+		}(w)
+	}
+
+	go func() {
+		defer func() {
+			close(objectChan)
+			wg.Wait()
+			close(resultChan)
+		}()
+		for _, o := range selected {
+			// the workers may all be busy so we cannot wait for one when interrupted
+			select {
+			case objectChan <- o:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// collect the results and update the checkpoint as we go
+	failures := make([]BackfillResult, 0)
+	successes := 0
+	for r := range resultChan {
+		if r.Interrupted == true {
+			continue
+		}
+		if r.Err != nil {
+			failures = append(failures, r)
+			continue
+		}
+		successes++
+		if checkpointFile != nil {
+			_, err = fmt.Fprintln(checkpointFile, r.Key)
+			if err != nil {
+				log.Printf("[main] WARNING: updating checkpoint file (%s)", err.Error())
+			}
+		}
+	}
+
+	// and the final report
+	fmt.Printf("backfill %s/%s complete in %0.2f seconds\n", *bucket, *prefix, time.Since(start).Seconds())
+	if ctx.Err() != nil {
+		fmt.Printf("INTERRUPTED: rerun with the same checkpoint file to resume\n")
+	}
+	// anything not completed or failed was never attempted (or was abandoned part way through)
+	notAttempted := len(selected) - successes - len(failures)
+	fmt.Printf("selected:      %d\n", len(selected))
+	fmt.Printf("skipped:       %d\n", skipped)
+	fmt.Printf("succeeded:     %d\n", successes)
+	fmt.Printf("failed:        %d\n", len(failures))
+	fmt.Printf("not attempted: %d\n", notAttempted)
+	for _, f := range failures {
+		fmt.Printf("FAILED: %s (%s)\n", f.Key, f.Err.Error())
+	}
+
+	if len(failures) != 0 || notAttempted != 0 {
+		return exitFailure
+	}
+	return exitSuccess
+}
+
+// select the objects to be processed, returns the selected objects and the number skipped
+func filterBackfillObjects(objects []BucketObject, suffixes []string, since time.Time, completed map[string]bool) ([]BucketObject, int) {
+
+	selected := make([]BucketObject, 0, len(objects))
+	skipped := 0
+	for _, o := range objects {
+		if hasAnySuffix(o.Key, suffixes) == false || o.LastModified.Before(since) == true || completed[o.Key] == true {
+			skipped++
+			continue
+		}
+		selected = append(selected, o)
+	}
+	return selected, skipped
+}
+
+// does the name have any of the specified suffixes (an empty suffix matches everything)
+func hasAnySuffix(name string, suffixes []string) bool {
+	for _, s := range suffixes {
+		if strings.HasSuffix(name, strings.TrimSpace(s)) == true {
+			return true
+		}
+	}
+	return false
+}
+
+// parse a date specified as YYYY-MM-DD or RFC3339
+func parseSinceDate(since string) (time.Time, error) {
+	t, err := time.Parse("2006-01-02", since)
+	if err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, since)
+}
+
+// load the set of keys recorded in the checkpoint file, a missing file is not an error
+func loadCheckpoint(filename string) (map[string]bool, error) {
+
+	completed := make(map[string]bool)
+	if len(filename) == 0 || fileExists(filename) == false {
+		return completed, nil
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		log.Printf("[main] ERROR: opening checkpoint file %s (%s)", filename, err.Error())
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key := strings.TrimSpace(scanner.Text())
+		if len(key) != 0 {
+			completed[key] = true
+		}
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		log.Printf("[main] ERROR: reading checkpoint file %s (%s)", filename, err.Error())
+		return nil, err
+	}
+
+	log.Printf("[main] INFO: loaded %d completed key(s) from checkpoint file %s", len(completed), filename)
+	return completed, nil
+}
+
+//
+// end of file
+//
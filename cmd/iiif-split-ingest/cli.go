@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+
+	"github.com/uvalib/uva-aws-s3-sdk/uva-s3"
+)
+
+// the command line exit codes
+var exitSuccess = 0
+var exitFailure = 1
+var exitUsage = 2
+
+// run one of the command line modes (if specified) and return true if we did so
+func runCommandLineMode(args []string) (bool, int) {
+
+	if len(args) == 0 {
+		return false, exitSuccess
+	}
+
+	switch args[0] {
+	case "ingest":
+		return true, ingestCommand(args[1:])
+	case "backfill":
+		return true, backfillCommand(args[1:])
+	case "verify":
+		return true, verifyCommand(args[1:])
+	}
+
+	return false, exitSuccess
+}
+
+// process a single S3 object or local file synchronously and report the outcome
+func ingestCommand(args []string) int {
+
+	flags := flag.NewFlagSet("ingest", flag.ContinueOnError)
+	bucket := flags.String("bucket", "", "the source bucket name")
+	key := flags.String("key", "", "the source object key")
+	file := flags.String("file", "", "the local source file (instead of a bucket and key)")
+	err := flags.Parse(args)
+	if err != nil {
+		return exitUsage
+	}
+
+	if (len(*file) == 0) == (len(*bucket) == 0 || len(*key) == 0) {
+		fmt.Fprintf(os.Stderr, "ingest: specify either -bucket and -key or -file\n")
+		flags.Usage()
+		return exitUsage
+	}
+
+	// the inbound source is specified on the command line
+	cfg := LoadConfiguration(false)
+
+	var notify Notify
+	if len(*file) != 0 {
+		info, err := os.Stat(*file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ingest: %s does not exist\n", *file)
+			return exitUsage
+		}
+		notify = Notify{SourceBucket: path.Dir(*file), BucketKey: path.Base(*file), ExpectedSize: info.Size(), LocalFile: *file}
+	} else {
+		notify = Notify{SourceBucket: *bucket, BucketKey: *key}
+	}
+
+	// we only need S3 if we are downloading from or writing to a bucket
+	var s3Svc uva_s3.UvaS3
+	if len(notify.LocalFile) == 0 || len(cfg.OutputBucket) != 0 {
+		s3Svc, err = uva_s3.NewUvaS3(uva_s3.UvaS3Config{Logging: true})
+		fatalIfError(err)
+	}
+
+	// an interrupt abandons the job and cleans up
+	ctx, stop := newShutdownContext()
+	defer stop()
+
+	summary, err := processWithRetries(ctx, ctx, 1, *cfg, s3Svc, notify)
+	printJobSummary(summary, err)
+
+	if err != nil {
+		return exitFailure
+	}
+	return exitSuccess
+}
+
+// print the job summary to stdout
+func printJobSummary(summary *JobSummary, err error) {
+
+	status := "SUCCESS"
+	if err != nil {
+		status = fmt.Sprintf("FAILED (%s)", err.Error())
+	}
+
+	fmt.Printf("source:   %s\n", summary.Source)
+	fmt.Printf("id:       %s\n", summary.Id)
+	fmt.Printf("status:   %s\n", status)
+	fmt.Printf("pages:    %d\n", summary.PageCount)
+	for _, o := range summary.Outputs {
+		fmt.Printf("output:   %s\n", o)
+	}
+	if len(summary.Manifest) != 0 {
+		fmt.Printf("manifest: %s\n", summary.Manifest)
+	}
+	fmt.Printf("duration: %0.2f seconds\n", summary.Duration.Seconds())
+	log.Printf("[main] INFO: %s %s", summary.Source, status)
+}
+
+//
+// end of file
+//
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// ErrCommandTimeout - an external command did not complete within its configured time
+var ErrCommandTimeout = fmt.Errorf("command timed out")
+
+// run an external command and return the combined output. The command (and anything it started) is
+// killed if it does not complete within the timeout (0 means no timeout) or if the context is cancelled
+func runCommand(ctx context.Context, timeout time.Duration, binary string, params []string) ([]byte, error) {
+
+	var output bytes.Buffer
+	cmd := exec.Command(binary, params...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	// run in a separate process group so we can kill any children too
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	err := cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	var expired <-chan time.Time
+	if timeout != 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case err = <-done:
+		return output.Bytes(), err
+
+	case <-expired:
+		killProcessGroup(cmd)
+		<-done
+		return output.Bytes(), fmt.Errorf("%w after %s", ErrCommandTimeout, timeout)
+
+	case <-ctx.Done():
+		killProcessGroup(cmd)
+		<-done
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) == true {
+			return output.Bytes(), fmt.Errorf("%w (stage deadline exceeded)", ErrCommandTimeout)
+		}
+		return output.Bytes(), ctx.Err()
+	}
+}
+
+// kill the process group of the specified command
+func killProcessGroup(cmd *exec.Cmd) {
+	_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// is this an error returned because a command timed out
+func isTimeout(err error) bool {
+	return errors.Is(err, ErrCommandTimeout)
+}
+
+//
+// end of file
+//
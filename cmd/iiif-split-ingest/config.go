@@ -10,24 +10,84 @@ import (
 type ServiceConfig struct {
 
 	// service configuration
-	InQueueName     string // SQS queue name for inbound documents
-	PollTimeOut     int64  // the SQS queue timeout (in seconds)
-	LocalWorkDir    string // the local work directory
-	WorkerQueueSize int    // the inbound message queue size to feed the workers
-	Workers         int    // the number of worker processes
+	InQueueName       string // SQS queue name for inbound documents
+	WatchDir          string // local directory to watch for inbound documents (instead of a queue)
+	WatchPollInterval int    // how often to poll the watch directory (in seconds)
+	PollTimeOut       int64  // the SQS queue timeout (in seconds)
+	LocalWorkDir      string // the local work directory
+	JournalDir        string // the job journal directory (if jobs can be resumed)
+	JanitorAge        int    // the age after which orphaned work directories are removed (in seconds)
+	JanitorInterval   int    // how often to look for orphaned work directories (in seconds, 0 for startup only)
+	WorkerQueueSize   int    // the inbound message queue size to feed the workers
+	Workers           int    // the number of worker processes
+
+	// disk space admission control
+	DiskExpansionFactor float64 // the work space needed by a job as a multiple of the source size (0 to disable)
+	DiskWaitTimeout     int     // how long a job waits for disk space before failing (in seconds)
+
+	// download verification
+	DownloadRetries int // the number of times a download that fails verification is retried
+
+	// retry policy
+	MaxAttempts     int // the maximum number of attempts for a job that fails with a transient error
+	RetryBackoff    int // the delay before the first retry, doubled for each one after (in seconds)
+	RetryBackoffMax int // the maximum delay between retries (in seconds)
+
+	// long running job support
+	VisibilityTimeout   int // the visibility timeout applied to in-flight messages (in seconds, 0 to disable)
+	VisibilityHeartbeat int // how often the visibility timeout is applied (in seconds)
+	ShutdownGracePeriod int // how long in-flight jobs have to complete during shutdown (in seconds)
+
+	// poison message support
+	DeadLetterQueueName string // SQS queue name for messages that cannot be processed
+	QuarantineDir       string // local directory for messages that cannot be processed
+
+	// completion event support
+	OutQueueName string // SQS queue name for outbound completion events (optional)
+
+	// webhook support
+	WebhookEndpoints string // comma separated list of endpoints notified of job lifecycle events (optional)
+	WebhookSecret    string // the shared secret used to sign webhook payloads
+	WebhookTimeout   int    // the webhook request timeout (in seconds)
 
 	// splitting configuration
 	SplitBinary              string // the file split binary
 	SplitSuffix              string // the suffix of split files
-	SplitCommandLine         string // the split commandline
-	SplitCommandInFileToken  string // the placeholder token for the input file
-	SplitCommandOutFileToken string // the placeholder token for the output file
+	SplitCommandLine         string // the split commandline template
+	SplitCommandInFileToken  string // the legacy placeholder token for the input file (optional, use {in})
+	SplitCommandOutFileToken string // the legacy placeholder token for the output file (optional, use {out})
+	SplitTimeout             int    // the split command timeout (in seconds, 0 for none)
+	Splitters                string // the splitter used for each MIME type (mime=splitter,...)
+	PdfSplitBinary           string // the binary used to render PDF pages (Ghostscript)
+	PdfSplitDevice           string // the output device used to render PDF pages
+	PdfSplitDpi              int    // the resolution used to render PDF pages
+	PageNumberPattern        string // regex with a capture group used to extract the page number from split file names (optional)
+	ArchiveMaxEntries        int    // the maximum number of entries in an archive
+	ArchiveMaxSize           int    // the maximum uncompressed size of an archive (in MB)
+	ArchivePageSuffixes      string // the comma separated suffixes of page images in an archive or group
+	ArchiveSequenceFile      string // the name of the optional page sequence file in an archive
+	GroupMarker              string // the name of the object that marks a prefix of page objects as complete (optional)
 
 	// conversion configuration
-	ConvertBinary      string // the conversion binary
-	ConvertSuffix      string // the suffix of converted files
-	ConvertCommandLine string // the conversion commandline
-	DeleteSource       bool   // delete the bucket object after processing
+	ConvertBinary              string      // the conversion binary
+	ConvertSuffix              string      // the suffix of converted files
+	ConvertCommandLine         string      // the conversion commandline template
+	ConvertCommandInFileToken  string      // the legacy placeholder token for the input file (optional, use {in})
+	ConvertCommandOutFileToken string      // the legacy placeholder token for the output file (optional, use {out})
+	ConvertPageTimeout         int         // the conversion command timeout for each page (in seconds, 0 for none)
+	ConvertTimeout             int         // the timeout to convert all pages of a document (in seconds, 0 for none)
+	PageWorkers                int         // the number of pages of a document converted at once
+	RenditionNames             string      // comma separated names of additional renditions of each page (optional)
+	Renditions                 []Rendition // the configuration of each additional rendition
+	ValidateOutput             bool        // validate each converted image before it is output
+	ValidateMinWidth           int         // the minimum width of a converted image
+	ValidateMinHeight          int         // the minimum height of a converted image
+	ValidateMaxWidth           int         // the maximum width of a converted image (0 for no limit)
+	ValidateMaxHeight          int         // the maximum height of a converted image (0 for no limit)
+	ValidateDecodeBinary       string      // the binary used to fully decode each converted image (optional, otherwise only the headers are checked)
+	ValidateDecodeCommandLine  string      // the decode commandline template ({in} is the image)
+	Fixity                     bool        // record the checksums of every output in sidecars (and the object metadata)
+	DeleteSource               bool        // delete the bucket object after processing
 
 	// output location support
 	OutputFSRoot       string // the converted image output directory
@@ -102,29 +162,105 @@ func envToBoolean(env string) bool {
 }
 
 // LoadConfiguration will load the service configuration from env/cmdline
-// and return a pointer to it. Any failures are fatal.
-func LoadConfiguration() *ServiceConfig {
+// and return a pointer to it. Any failures are fatal. The inbound source is
+// only required when running as a service.
+func LoadConfiguration(requireInbound bool) *ServiceConfig {
 
 	var cfg ServiceConfig
 
 	// service configuration
-	cfg.InQueueName = ensureSetAndNonEmpty("IIIF_INGEST_IN_QUEUE")
-	cfg.PollTimeOut = int64(envToInt("IIIF_INGEST_QUEUE_POLL_TIMEOUT"))
+	cfg.InQueueName = envWithDefault("IIIF_INGEST_IN_QUEUE", "")
+	cfg.WatchDir = envWithDefault("IIIF_INGEST_WATCH_DIR", "")
+	cfg.WatchPollInterval, _ = strconv.Atoi(envWithDefault("IIIF_INGEST_WATCH_POLL_INTERVAL", "5"))
 	cfg.LocalWorkDir = ensureSetAndNonEmpty("IIIF_INGEST_WORK_DIR")
-	cfg.WorkerQueueSize = envToInt("IIIF_INGEST_WORK_QUEUE_SIZE")
-	cfg.Workers = envToInt("IIIF_INGEST_WORKERS")
+	cfg.JournalDir = envWithDefault("IIIF_INGEST_JOURNAL_DIR", "")
+	cfg.JanitorAge, _ = strconv.Atoi(envWithDefault("IIIF_INGEST_JANITOR_AGE", "86400"))
+	cfg.JanitorInterval, _ = strconv.Atoi(envWithDefault("IIIF_INGEST_JANITOR_INTERVAL", "3600"))
+
+	// the queue settings are only required when running as a service, the command line modes process one
+	// file at a time unless told otherwise
+	if requireInbound == true {
+		cfg.PollTimeOut = int64(envToInt("IIIF_INGEST_QUEUE_POLL_TIMEOUT"))
+		cfg.WorkerQueueSize = envToInt("IIIF_INGEST_WORK_QUEUE_SIZE")
+		cfg.Workers = envToInt("IIIF_INGEST_WORKERS")
+	} else {
+		cfg.PollTimeOut, _ = strconv.ParseInt(envWithDefault("IIIF_INGEST_QUEUE_POLL_TIMEOUT", "20"), 10, 64)
+		cfg.WorkerQueueSize, _ = strconv.Atoi(envWithDefault("IIIF_INGEST_WORK_QUEUE_SIZE", "1"))
+		cfg.Workers, _ = strconv.Atoi(envWithDefault("IIIF_INGEST_WORKERS", "1"))
+	}
+
+	// disk space admission control
+	cfg.DiskExpansionFactor, _ = strconv.ParseFloat(envWithDefault("IIIF_INGEST_DISK_EXPANSION_FACTOR", "0"), 64)
+	cfg.DiskWaitTimeout, _ = strconv.Atoi(envWithDefault("IIIF_INGEST_DISK_WAIT_TIMEOUT", "600"))
+
+	// download verification
+	cfg.DownloadRetries, _ = strconv.Atoi(envWithDefault("IIIF_INGEST_DOWNLOAD_RETRIES", "3"))
+
+	// retry policy
+	cfg.MaxAttempts, _ = strconv.Atoi(envWithDefault("IIIF_INGEST_MAX_ATTEMPTS", "3"))
+	cfg.RetryBackoff, _ = strconv.Atoi(envWithDefault("IIIF_INGEST_RETRY_BACKOFF", "5"))
+	cfg.RetryBackoffMax, _ = strconv.Atoi(envWithDefault("IIIF_INGEST_RETRY_BACKOFF_MAX", "300"))
+
+	// long running job support
+	cfg.VisibilityTimeout, _ = strconv.Atoi(envWithDefault("IIIF_INGEST_VISIBILITY_TIMEOUT", "0"))
+	cfg.VisibilityHeartbeat, _ = strconv.Atoi(envWithDefault("IIIF_INGEST_VISIBILITY_HEARTBEAT", "60"))
+	cfg.ShutdownGracePeriod, _ = strconv.Atoi(envWithDefault("IIIF_INGEST_SHUTDOWN_GRACE_PERIOD", "30"))
+
+	// poison message support
+	cfg.DeadLetterQueueName = envWithDefault("IIIF_INGEST_DEAD_LETTER_QUEUE", "")
+	cfg.QuarantineDir = envWithDefault("IIIF_INGEST_QUARANTINE_DIR", "")
+
+	// completion event support
+	cfg.OutQueueName = envWithDefault("IIIF_INGEST_OUT_QUEUE", "")
+
+	// webhook support
+	cfg.WebhookEndpoints = envWithDefault("IIIF_INGEST_WEBHOOK_ENDPOINTS", "")
+	cfg.WebhookSecret = envWithDefault("IIIF_INGEST_WEBHOOK_SECRET", "")
+	cfg.WebhookTimeout, _ = strconv.Atoi(envWithDefault("IIIF_INGEST_WEBHOOK_TIMEOUT", "15"))
 
 	// splitting configuration
 	cfg.SplitBinary = envWithDefault("IIIF_INGEST_SPLIT_BIN", "")
 	cfg.SplitSuffix = envWithDefault("IIIF_INGEST_SPLIT_SUFFIX", "")
 	cfg.SplitCommandLine = envWithDefault("IIIF_INGEST_SPLIT_CMD", "")
-	cfg.SplitCommandInFileToken = ensureSetAndNonEmpty("IIIF_INGEST_SPLIT_CMD_INFILE_TOKEN")
-	cfg.SplitCommandOutFileToken = ensureSetAndNonEmpty("IIIF_INGEST_SPLIT_CMD_OUTFILE_TOKEN")
+	cfg.SplitCommandInFileToken = envWithDefault("IIIF_INGEST_SPLIT_CMD_INFILE_TOKEN", "")
+	cfg.SplitCommandOutFileToken = envWithDefault("IIIF_INGEST_SPLIT_CMD_OUTFILE_TOKEN", "")
+	cfg.SplitTimeout, _ = strconv.Atoi(envWithDefault("IIIF_INGEST_SPLIT_TIMEOUT", "0"))
+
+	// by default we use the split command if one is configured, otherwise we do not split
+	defaultSplitters := "*=none"
+	if len(cfg.SplitBinary) != 0 {
+		defaultSplitters = "*=command"
+	}
+	cfg.Splitters = envWithDefault("IIIF_INGEST_SPLITTERS", defaultSplitters)
+	cfg.PdfSplitBinary = envWithDefault("IIIF_INGEST_PDF_SPLIT_BIN", "gs")
+	cfg.PdfSplitDevice = envWithDefault("IIIF_INGEST_PDF_SPLIT_DEVICE", "tiff24nc")
+	cfg.PdfSplitDpi, _ = strconv.Atoi(envWithDefault("IIIF_INGEST_PDF_SPLIT_DPI", "300"))
+	cfg.PageNumberPattern = envWithDefault("IIIF_INGEST_PAGE_NUMBER_PATTERN", "")
+	cfg.ArchiveMaxEntries, _ = strconv.Atoi(envWithDefault("IIIF_INGEST_ARCHIVE_MAX_ENTRIES", "10000"))
+	cfg.ArchiveMaxSize, _ = strconv.Atoi(envWithDefault("IIIF_INGEST_ARCHIVE_MAX_SIZE", "20480"))
+	cfg.ArchivePageSuffixes = envWithDefault("IIIF_INGEST_ARCHIVE_PAGE_SUFFIXES", ".tif,.tiff,.jpg,.jpeg,.png,.jp2")
+	cfg.ArchiveSequenceFile = envWithDefault("IIIF_INGEST_ARCHIVE_SEQUENCE_FILE", "sequence.txt")
+	cfg.GroupMarker = envWithDefault("IIIF_INGEST_GROUP_MARKER", "")
 
 	// conversion configuration
 	cfg.ConvertBinary = ensureSetAndNonEmpty("IIIF_INGEST_CONVERT_BIN")
 	cfg.ConvertSuffix = ensureSetAndNonEmpty("IIIF_INGEST_CONVERT_SUFFIX")
 	cfg.ConvertCommandLine = ensureSetAndNonEmpty("IIIF_INGEST_CONVERT_CMD")
+	// the conversion command historically used the split tokens
+	cfg.ConvertCommandInFileToken = envWithDefault("IIIF_INGEST_CONVERT_CMD_INFILE_TOKEN", cfg.SplitCommandInFileToken)
+	cfg.ConvertCommandOutFileToken = envWithDefault("IIIF_INGEST_CONVERT_CMD_OUTFILE_TOKEN", cfg.SplitCommandOutFileToken)
+	cfg.ConvertPageTimeout, _ = strconv.Atoi(envWithDefault("IIIF_INGEST_CONVERT_PAGE_TIMEOUT", "0"))
+	cfg.ConvertTimeout, _ = strconv.Atoi(envWithDefault("IIIF_INGEST_CONVERT_TIMEOUT", "0"))
+	cfg.PageWorkers, _ = strconv.Atoi(envWithDefault("IIIF_INGEST_PAGE_WORKERS", "1"))
+	cfg.RenditionNames = envWithDefault("IIIF_INGEST_RENDITIONS", "")
+	cfg.ValidateOutput, _ = strconv.ParseBool(envWithDefault("IIIF_INGEST_VALIDATE_OUTPUT", "false"))
+	cfg.ValidateMinWidth, _ = strconv.Atoi(envWithDefault("IIIF_INGEST_VALIDATE_MIN_WIDTH", "1"))
+	cfg.ValidateMinHeight, _ = strconv.Atoi(envWithDefault("IIIF_INGEST_VALIDATE_MIN_HEIGHT", "1"))
+	cfg.ValidateMaxWidth, _ = strconv.Atoi(envWithDefault("IIIF_INGEST_VALIDATE_MAX_WIDTH", "0"))
+	cfg.ValidateMaxHeight, _ = strconv.Atoi(envWithDefault("IIIF_INGEST_VALIDATE_MAX_HEIGHT", "0"))
+	cfg.ValidateDecodeBinary = envWithDefault("IIIF_INGEST_VALIDATE_DECODE_BIN", "")
+	cfg.ValidateDecodeCommandLine = envWithDefault("IIIF_INGEST_VALIDATE_DECODE_CMD", "{in} null:")
+	cfg.Fixity, _ = strconv.ParseBool(envWithDefault("IIIF_INGEST_FIXITY", "false"))
 	cfg.DeleteSource = envToBoolean("IIIF_INGEST_DELETE_SOURCE")
 
 	// output configuration
@@ -151,22 +287,81 @@ func LoadConfiguration() *ServiceConfig {
 
 	// service configuration
 	log.Printf("[CONFIG] InQueueName                   = [%s]", cfg.InQueueName)
+	log.Printf("[CONFIG] WatchDir                      = [%s]", cfg.WatchDir)
+	log.Printf("[CONFIG] WatchPollInterval             = [%d]", cfg.WatchPollInterval)
 	log.Printf("[CONFIG] PollTimeOut                   = [%d]", cfg.PollTimeOut)
 	log.Printf("[CONFIG] LocalWorkDir                  = [%s]", cfg.LocalWorkDir)
+	log.Printf("[CONFIG] JournalDir                    = [%s]", cfg.JournalDir)
+	log.Printf("[CONFIG] JanitorAge                    = [%d]", cfg.JanitorAge)
+	log.Printf("[CONFIG] JanitorInterval               = [%d]", cfg.JanitorInterval)
 	log.Printf("[CONFIG] WorkerQueueSize               = [%d]", cfg.WorkerQueueSize)
 	log.Printf("[CONFIG] Workers                       = [%d]", cfg.Workers)
 
+	// disk space admission control
+	log.Printf("[CONFIG] DiskExpansionFactor           = [%0.2f]", cfg.DiskExpansionFactor)
+	log.Printf("[CONFIG] DiskWaitTimeout               = [%d]", cfg.DiskWaitTimeout)
+
+	// download verification
+	log.Printf("[CONFIG] DownloadRetries               = [%d]", cfg.DownloadRetries)
+
+	// retry policy
+	log.Printf("[CONFIG] MaxAttempts                   = [%d]", cfg.MaxAttempts)
+	log.Printf("[CONFIG] RetryBackoff                  = [%d]", cfg.RetryBackoff)
+	log.Printf("[CONFIG] RetryBackoffMax               = [%d]", cfg.RetryBackoffMax)
+
+	// long running job support
+	log.Printf("[CONFIG] VisibilityTimeout             = [%d]", cfg.VisibilityTimeout)
+	log.Printf("[CONFIG] VisibilityHeartbeat           = [%d]", cfg.VisibilityHeartbeat)
+	log.Printf("[CONFIG] ShutdownGracePeriod           = [%d]", cfg.ShutdownGracePeriod)
+
+	// poison message support
+	log.Printf("[CONFIG] DeadLetterQueueName           = [%s]", cfg.DeadLetterQueueName)
+	log.Printf("[CONFIG] QuarantineDir                 = [%s]", cfg.QuarantineDir)
+
+	// completion event support
+	log.Printf("[CONFIG] OutQueueName                  = [%s]", cfg.OutQueueName)
+
+	// webhook support
+	log.Printf("[CONFIG] WebhookEndpoints              = [%s]", cfg.WebhookEndpoints)
+	log.Printf("[CONFIG] WebhookSecret                 = [REDACTED]")
+	log.Printf("[CONFIG] WebhookTimeout                = [%d]", cfg.WebhookTimeout)
+
 	// splitting configuration
 	log.Printf("[CONFIG] SplitBinary                   = [%s]", cfg.SplitBinary)
 	log.Printf("[CONFIG] SplitSuffix                   = [%s]", cfg.SplitSuffix)
 	log.Printf("[CONFIG] SplitCommandLine              = [%s]", cfg.SplitCommandLine)
 	log.Printf("[CONFIG] SplitCommandInFileToken       = [%s]", cfg.SplitCommandInFileToken)
 	log.Printf("[CONFIG] SplitCommandOutFileToken      = [%s]", cfg.SplitCommandOutFileToken)
+	log.Printf("[CONFIG] SplitTimeout                  = [%d]", cfg.SplitTimeout)
+	log.Printf("[CONFIG] Splitters                     = [%s]", cfg.Splitters)
+	log.Printf("[CONFIG] PdfSplitBinary                = [%s]", cfg.PdfSplitBinary)
+	log.Printf("[CONFIG] PdfSplitDevice                = [%s]", cfg.PdfSplitDevice)
+	log.Printf("[CONFIG] PdfSplitDpi                   = [%d]", cfg.PdfSplitDpi)
+	log.Printf("[CONFIG] PageNumberPattern             = [%s]", cfg.PageNumberPattern)
+	log.Printf("[CONFIG] ArchiveMaxEntries             = [%d]", cfg.ArchiveMaxEntries)
+	log.Printf("[CONFIG] ArchiveMaxSize                = [%d]", cfg.ArchiveMaxSize)
+	log.Printf("[CONFIG] ArchivePageSuffixes           = [%s]", cfg.ArchivePageSuffixes)
+	log.Printf("[CONFIG] ArchiveSequenceFile           = [%s]", cfg.ArchiveSequenceFile)
+	log.Printf("[CONFIG] GroupMarker                   = [%s]", cfg.GroupMarker)
 
 	// conversion configuration
 	log.Printf("[CONFIG] ConvertBinary                 = [%s]", cfg.ConvertBinary)
 	log.Printf("[CONFIG] ConvertSuffix                 = [%s]", cfg.ConvertSuffix)
 	log.Printf("[CONFIG] ConvertCommandLine            = [%s]", cfg.ConvertCommandLine)
+	log.Printf("[CONFIG] ConvertCommandInFileToken     = [%s]", cfg.ConvertCommandInFileToken)
+	log.Printf("[CONFIG] ConvertCommandOutFileToken    = [%s]", cfg.ConvertCommandOutFileToken)
+	log.Printf("[CONFIG] ConvertPageTimeout            = [%d]", cfg.ConvertPageTimeout)
+	log.Printf("[CONFIG] ConvertTimeout                = [%d]", cfg.ConvertTimeout)
+	log.Printf("[CONFIG] PageWorkers                   = [%d]", cfg.PageWorkers)
+	log.Printf("[CONFIG] RenditionNames                = [%s]", cfg.RenditionNames)
+	log.Printf("[CONFIG] ValidateOutput                = [%t]", cfg.ValidateOutput)
+	log.Printf("[CONFIG] ValidateMinWidth              = [%d]", cfg.ValidateMinWidth)
+	log.Printf("[CONFIG] ValidateMinHeight             = [%d]", cfg.ValidateMinHeight)
+	log.Printf("[CONFIG] ValidateMaxWidth              = [%d]", cfg.ValidateMaxWidth)
+	log.Printf("[CONFIG] ValidateMaxHeight             = [%d]", cfg.ValidateMaxHeight)
+	log.Printf("[CONFIG] ValidateDecodeBinary          = [%s]", cfg.ValidateDecodeBinary)
+	log.Printf("[CONFIG] ValidateDecodeCommandLine     = [%s]", cfg.ValidateDecodeCommandLine)
+	log.Printf("[CONFIG] Fixity                        = [%t]", cfg.Fixity)
 	log.Printf("[CONFIG] DeleteSource                  = [%t]", cfg.DeleteSource)
 
 	// output location support
@@ -190,6 +385,88 @@ func LoadConfiguration() *ServiceConfig {
 	// static metadata support
 	log.Printf("[CONFIG] ManifestMetadataCopyrightText = [%s]", cfg.ManifestMetadataCopyrightText)
 
+	// validate inbound source values
+	if requireInbound == true && len(cfg.InQueueName) == 0 && len(cfg.WatchDir) == 0 {
+		log.Printf("[main] ERROR: must specify inbound queue (IIIF_INGEST_IN_QUEUE) or watch directory (IIIF_INGEST_WATCH_DIR)")
+		os.Exit(1)
+	}
+
+	if len(cfg.InQueueName) != 0 && len(cfg.WatchDir) != 0 {
+		log.Printf("[main] ERROR: cannot specify inbound queue (IIIF_INGEST_IN_QUEUE) and watch directory (IIIF_INGEST_WATCH_DIR)")
+		os.Exit(1)
+	}
+
+	// watching a directory does not use AWS so output must be to the filesystem
+	if len(cfg.WatchDir) != 0 {
+		if fileExists(cfg.WatchDir) == false {
+			log.Printf("[main] ERROR: watch directory [%s] does not exist", cfg.WatchDir)
+			os.Exit(1)
+		}
+		if len(cfg.OutputBucket) != 0 {
+			log.Printf("[main] ERROR: cannot specify output bucket (IIIF_INGEST_OUTPUT_BUCKET) when watching a directory")
+			os.Exit(1)
+		}
+		if len(cfg.GroupMarker) != 0 {
+			log.Printf("[main] ERROR: cannot specify group marker (IIIF_INGEST_GROUP_MARKER) when watching a directory")
+			os.Exit(1)
+		}
+		if cfg.WatchPollInterval <= 0 {
+			log.Printf("[main] ERROR: watch poll interval must be greater than zero")
+			os.Exit(1)
+		}
+	}
+
+	if cfg.Workers <= 0 {
+		log.Printf("[main] ERROR: workers must be greater than zero")
+		os.Exit(1)
+	}
+
+	if cfg.PageWorkers <= 0 {
+		log.Printf("[main] ERROR: page workers must be greater than zero")
+		os.Exit(1)
+	}
+
+	if cfg.ValidateOutput == true {
+		if cfg.ValidateMinWidth < 1 || cfg.ValidateMinHeight < 1 || cfg.ValidateMaxWidth < 0 || cfg.ValidateMaxHeight < 0 {
+			log.Printf("[main] ERROR: validation dimensions are invalid")
+			os.Exit(1)
+		}
+		if (cfg.ValidateMaxWidth != 0 && cfg.ValidateMaxWidth < cfg.ValidateMinWidth) ||
+			(cfg.ValidateMaxHeight != 0 && cfg.ValidateMaxHeight < cfg.ValidateMinHeight) {
+			log.Printf("[main] ERROR: validation maximum dimensions are less than the minimum")
+			os.Exit(1)
+		}
+		if len(cfg.ValidateDecodeBinary) != 0 {
+			err := validateCommandTemplate(cfg.ValidateDecodeCommandLine, decodeTemplateVariables)
+			if err != nil {
+				log.Printf("[main] ERROR: decode command (IIIF_INGEST_VALIDATE_DECODE_CMD) is invalid (%s)", err.Error())
+				os.Exit(1)
+			}
+		}
+	}
+
+	// webhook payloads must be signed
+	if len(cfg.WebhookEndpoints) != 0 && len(cfg.WebhookSecret) == 0 {
+		log.Printf("[main] ERROR: webhook secret (IIIF_INGEST_WEBHOOK_SECRET) is required when webhook endpoints are configured")
+		os.Exit(1)
+	}
+
+	if cfg.MaxAttempts <= 0 {
+		log.Printf("[main] ERROR: max attempts must be greater than zero")
+		os.Exit(1)
+	}
+
+	// validate the visibility configuration, the heartbeat must happen before the timeout expires
+	if cfg.VisibilityTimeout != 0 {
+		if cfg.VisibilityHeartbeat <= 0 || cfg.VisibilityHeartbeat >= cfg.VisibilityTimeout {
+			log.Printf("[main] ERROR: visibility heartbeat must be greater than zero and less than the visibility timeout")
+			os.Exit(1)
+		}
+	}
+
+	// the additional renditions, their output locations default to locations under the primary output
+	cfg.Renditions = loadRenditions(&cfg)
+
 	// validate output target values
 	if len(cfg.OutputFSRoot) == 0 && len(cfg.OutputBucket) == 0 {
 		log.Printf("[main] ERROR: must specify output root (IIIF_INGEST_OUTPUT_ROOT) or output bucket (IIIF_INGEST_OUTPUT_BUCKET)")
@@ -201,10 +478,88 @@ func LoadConfiguration() *ServiceConfig {
 		os.Exit(1)
 	}
 
-	// validate the config if we have splitting behavior
+	// validate the journal directory
+	if len(cfg.JournalDir) != 0 {
+		if fileExists(cfg.JournalDir) == false {
+			log.Printf("[main] ERROR: journal directory [%s] does not exist", cfg.JournalDir)
+			os.Exit(1)
+		}
+	}
+
+	// failed jobs are removed from the inbound queue once quarantined, without somewhere to put them they
+	// would be redelivered forever
+	if requireInbound == true && len(cfg.InQueueName) != 0 && len(cfg.DeadLetterQueueName) == 0 && len(cfg.QuarantineDir) == 0 {
+		log.Printf("[main] ERROR: must specify dead letter queue (IIIF_INGEST_DEAD_LETTER_QUEUE) or quarantine directory (IIIF_INGEST_QUARANTINE_DIR) when using an inbound queue")
+		os.Exit(1)
+	}
+
+	// validate the quarantine directory
+	if len(cfg.QuarantineDir) != 0 {
+		if fileExists(cfg.QuarantineDir) == false {
+			log.Printf("[main] ERROR: quarantine directory [%s] does not exist", cfg.QuarantineDir)
+			os.Exit(1)
+		}
+	}
+
+	// convert any legacy placeholder tokens into template variables and validate the command templates
+	cfg.SplitCommandLine = legacyCommandTemplate(cfg.SplitCommandLine, cfg.SplitCommandInFileToken, cfg.SplitCommandOutFileToken)
+	cfg.ConvertCommandLine = legacyCommandTemplate(cfg.ConvertCommandLine, cfg.ConvertCommandInFileToken, cfg.ConvertCommandOutFileToken)
 	if len(cfg.SplitBinary) != 0 {
-		if len(cfg.SplitSuffix) == 0 || len(cfg.SplitCommandLine) == 0 ||
-			len(cfg.SplitCommandInFileToken) == 0 || len(cfg.SplitCommandOutFileToken) == 0 {
+		err := validateCommandTemplate(cfg.SplitCommandLine, splitTemplateVariables)
+		if err != nil {
+			log.Printf("[main] ERROR: split command (IIIF_INGEST_SPLIT_CMD) is invalid (%s)", err.Error())
+			os.Exit(1)
+		}
+		log.Printf("[main] INFO: effective split command template [%s]", cfg.SplitCommandLine)
+	}
+	err := validateCommandTemplate(cfg.ConvertCommandLine, convertTemplateVariables)
+	if err != nil {
+		log.Printf("[main] ERROR: convert command (IIIF_INGEST_CONVERT_CMD) is invalid (%s)", err.Error())
+		os.Exit(1)
+	}
+	log.Printf("[main] INFO: effective convert command template [%s]", cfg.ConvertCommandLine)
+
+	if len(cfg.PageNumberPattern) != 0 {
+		_, err = compilePageNumberPattern(cfg.PageNumberPattern)
+		if err != nil {
+			log.Printf("[main] ERROR: page number pattern (IIIF_INGEST_PAGE_NUMBER_PATTERN) is invalid (%s)", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	// validate the splitter selection
+	rules, err := parseSplitterRules(cfg.Splitters)
+	if err != nil {
+		log.Printf("[main] ERROR: splitter configuration (IIIF_INGEST_SPLITTERS) is invalid (%s)", err.Error())
+		os.Exit(1)
+	}
+	splitting := false
+	for _, r := range rules {
+		switch r.Splitter {
+		case "none":
+			continue
+		case "command":
+			if len(cfg.SplitBinary) == 0 {
+				log.Printf("[main] ERROR: the command splitter requires a split binary (IIIF_INGEST_SPLIT_BIN)")
+				os.Exit(1)
+			}
+		case "pdf":
+			if len(cfg.PdfSplitBinary) == 0 || len(cfg.PdfSplitDevice) == 0 || cfg.PdfSplitDpi <= 0 {
+				log.Printf("[main] ERROR: pdf split configuration incomplete")
+				os.Exit(1)
+			}
+		case "archive":
+			if cfg.ArchiveMaxEntries <= 0 || cfg.ArchiveMaxSize <= 0 || len(cfg.ArchivePageSuffixes) == 0 {
+				log.Printf("[main] ERROR: archive split configuration incomplete")
+				os.Exit(1)
+			}
+		}
+		splitting = true
+	}
+
+	// validate the config if we have splitting behavior
+	if splitting == true {
+		if len(cfg.SplitBinary) != 0 && (len(cfg.SplitSuffix) == 0 || len(cfg.SplitCommandLine) == 0) {
 			log.Printf("[main] ERROR: split configuration incomplete")
 			os.Exit(1)
 		}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrInsufficientDiskSpace - there was not enough free space in the work directory to admit the job
+var ErrInsufficientDiskSpace = fmt.Errorf("insufficient disk space")
+
+// the longest we sleep between checks for free space
+var maxDiskSpaceBackoff = 60 * time.Second
+
+// DiskReservation - the disk space reserved by a running job. Once the job has a work directory the space it
+// has already used there is no longer counted as reserved (it is no longer free either)
+type DiskReservation struct {
+	need    uint64 // the space reserved
+	workDir string // the job work directory (once known)
+}
+
+// the disk space reserved by jobs currently running
+var reservedDiskSpace = struct {
+	sync.Mutex
+	jobs map[*DiskReservation]bool
+}{jobs: make(map[*DiskReservation]bool)}
+
+// wait until there is enough free space in the work directory for a job of the specified size and reserve it.
+// Returns the reservation which must be released once the job is complete (nil if nothing was reserved)
+func admitJob(ctx context.Context, workerId int, config ServiceConfig, expectedSize int64) (*DiskReservation, error) {
+
+	// we cannot estimate the space needed so just let it through
+	if expectedSize <= 0 || config.DiskExpansionFactor <= 0 {
+		return nil, nil
+	}
+
+	need := uint64(float64(expectedSize) * config.DiskExpansionFactor)
+	deadline := time.Now().Add(time.Duration(config.DiskWaitTimeout) * time.Second)
+	backoff := 1 * time.Second
+
+	for {
+		reservation, free, total, err := reserveDiskSpace(config.LocalWorkDir, need)
+		if err != nil {
+			log.Printf("[worker %d] WARNING: cannot determine free space in %s (%s), admitting job", workerId, config.LocalWorkDir, err.Error())
+			return nil, nil
+		}
+
+		if reservation != nil {
+			log.Printf("[worker %d] DEBUG: reserved %d bytes of disk space (%d available)", workerId, need, free)
+			return reservation, nil
+		}
+
+		// waiting will never help, the estimate must be wrong so let the job try
+		if need > total {
+			log.Printf("[worker %d] WARNING: estimated %d bytes of disk space exceeds the %d byte volume, admitting job without a reservation", workerId, need, total)
+			return nil, nil
+		}
+
+		if time.Now().After(deadline) == true {
+			log.Printf("[worker %d] ERROR: gave up waiting for %d bytes of disk space (%d available)", workerId, need, free)
+			return nil, fmt.Errorf("%w: need %d bytes, %d available", ErrInsufficientDiskSpace, need, free)
+		}
+
+		log.Printf("[worker %d] WARNING: waiting for %d bytes of disk space (%d available), sleeping %s", workerId, need, free, backoff)
+		if sleepUnlessStopped(ctx, backoff) == false {
+			return nil, ctx.Err()
+		}
+
+		// back off a bit more each time
+		backoff *= 2
+		if backoff > maxDiskSpaceBackoff {
+			backoff = maxDiskSpaceBackoff
+		}
+	}
+}
+
+// reserve the space if it is available. Returns the reservation (nil if the space is not available), the space
+// available (less the outstanding reservations) and the total size of the filesystem
+func reserveDiskSpace(dir string, need uint64) (*DiskReservation, uint64, uint64, error) {
+
+	// walking the work directories takes a while so it is done without holding the lock
+	reservedDiskSpace.Lock()
+	workDirs := make(map[*DiskReservation]string, len(reservedDiskSpace.jobs))
+	for r := range reservedDiskSpace.jobs {
+		workDirs[r] = r.workDir
+	}
+	reservedDiskSpace.Unlock()
+
+	used := make(map[*DiskReservation]uint64, len(workDirs))
+	for r, workDir := range workDirs {
+		if len(workDir) != 0 {
+			used[r] = dirUsage(workDir)
+		}
+	}
+
+	free, total, err := freeDiskSpace(dir)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	reservedDiskSpace.Lock()
+	defer reservedDiskSpace.Unlock()
+
+	// the space reserved but not yet used by the running jobs. Anything reserved since we looked counts in full
+	outstanding := uint64(0)
+	for r := range reservedDiskSpace.jobs {
+		workDir, seen := workDirs[r]
+		if seen == false || workDir != r.workDir {
+			outstanding += r.need
+			continue
+		}
+		outstanding += r.outstanding(used[r])
+	}
+
+	available := uint64(0)
+	if free > outstanding {
+		available = free - outstanding
+	}
+
+	if available < need {
+		return nil, available, total, nil
+	}
+
+	reservation := &DiskReservation{need: need}
+	reservedDiskSpace.jobs[reservation] = true
+	return reservation, available, total, nil
+}
+
+// the job work directory is known, the space used there counts against the reservation
+func (r *DiskReservation) track(workDir string) {
+	if r == nil {
+		return
+	}
+	reservedDiskSpace.Lock()
+	defer reservedDiskSpace.Unlock()
+	r.workDir = workDir
+}
+
+// the job is complete, release the reservation
+func (r *DiskReservation) release() {
+	if r == nil {
+		return
+	}
+	reservedDiskSpace.Lock()
+	defer reservedDiskSpace.Unlock()
+	delete(reservedDiskSpace.jobs, r)
+}
+
+// the part of the reservation not yet used given the space used in the work directory
+func (r *DiskReservation) outstanding(used uint64) uint64 {
+
+	if used >= r.need {
+		return 0
+	}
+	return r.need - used
+}
+
+// the space used by the files in a directory tree, errors are ignored (the job may be removing files)
+func dirUsage(dir string) uint64 {
+
+	used := uint64(0)
+	_ = filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() == true {
+			if info, e := d.Info(); e == nil {
+				used += uint64(info.Size())
+			}
+		}
+		return nil
+	})
+	return used
+}
+
+// the free space available to us and the total size of the filesystem containing the directory
+func freeDiskSpace(dir string) (uint64, uint64, error) {
+	var st syscall.Statfs_t
+	err := syscall.Statfs(dir, &st)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), uint64(st.Blocks) * uint64(st.Bsize), nil
+}
+
+//
+// end of file
+//
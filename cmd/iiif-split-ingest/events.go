@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/uvalib/virgo4-sqs-sdk/awssqs"
+)
+
+// the outbound event types
+var eventTypeSucceeded = "ingest.succeeded"
+var eventTypeFailed = "ingest.failed"
+
+// the attribute name used to identify the event type without decoding the payload
+var attributeKeyEventType = "event-type"
+
+// CompletionEvent - the structure published to the outbound queue when a job completes
+type CompletionEvent struct {
+	Event        string   `json:"event"`                 // the event type
+	SourceBucket string   `json:"source_bucket"`         // the source bucket (or directory)
+	SourceKey    string   `json:"source_key"`            // the source key (or file name)
+	Id           string   `json:"id"`                    // the item id
+	PageCount    int      `json:"page_count"`            // the number of pages converted
+	Outputs      []string `json:"outputs"`               // the output file locations
+	Manifest     string   `json:"manifest,omitempty"`    // the manifest file (if one was created)
+	Started      string   `json:"started"`               // when processing started
+	Finished     string   `json:"finished,omitempty"`    // when processing finished
+	Duration     float64  `json:"duration_seconds"`      // the total processing time including any retries
+	Attempts     int      `json:"attempts"`              // the number of attempts made
+	ErrorClass   string   `json:"error_class,omitempty"` // the error class (failures only)
+	Error        string   `json:"error,omitempty"`       // the error (failures only)
+}
+
+// EventPublisher - publishes completion events to the outbound queue
+type EventPublisher struct {
+	aws   awssqs.AWS_SQS     // the SQS helper
+	queue awssqs.QueueHandle // the outbound queue
+}
+
+// create the event publisher, returns nil if no outbound queue is configured
+func newEventPublisher(aws awssqs.AWS_SQS, queueName string) (*EventPublisher, error) {
+
+	if len(queueName) == 0 {
+		return nil, nil
+	}
+
+	queue, err := aws.QueueHandle(queueName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EventPublisher{aws: aws, queue: queue}, nil
+}
+
+// create the completion event for a job
+func newCompletionEvent(notify Notify, summary *JobSummary, start time.Time, jobErr error) CompletionEvent {
+
+	finished := time.Now()
+	event := CompletionEvent{
+		Event:        eventTypeSucceeded,
+		SourceBucket: notify.SourceBucket,
+		SourceKey:    notify.BucketKey,
+		Outputs:      make([]string, 0),
+		Started:      start.UTC().Format(time.RFC3339),
+		Finished:     finished.UTC().Format(time.RFC3339),
+		Duration:     finished.Sub(start).Seconds(),
+	}
+
+	if summary != nil {
+		event.Id = summary.Id
+		event.PageCount = summary.PageCount
+		event.Outputs = summary.Outputs
+		event.Manifest = summary.Manifest
+		event.Attempts = summary.Attempts
+	}
+
+	if jobErr != nil {
+		event.Event = eventTypeFailed
+		event.Error = jobErr.Error()
+		event.ErrorClass = classifyError(jobErr)
+		var failure *JobFailure
+		if errors.As(jobErr, &failure) == true {
+			event.ErrorClass = failure.Class
+			event.Attempts = failure.Attempts
+		}
+	}
+
+	return event
+}
+
+// publish an event to the outbound queue, nothing happens if we are not configured. Failures are logged
+// but do not affect the outcome of the job
+func (p *EventPublisher) publish(workerId int, event CompletionEvent) error {
+
+	if p == nil {
+		return nil
+	}
+
+	b, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("[worker %d] ERROR: json marshal (%s)", workerId, err.Error())
+		return err
+	}
+
+	attribs := awssqs.Attributes{{Name: attributeKeyEventType, Value: event.Event}}
+	messages := []awssqs.Message{{Attribs: attribs, Payload: b}}
+	opStatus, err := p.aws.BatchMessagePut(p.queue, messages)
+	if err != nil {
+		if err != awssqs.ErrOneOrMoreOperationsUnsuccessful {
+			log.Printf("[worker %d] ERROR: failed to publish %s event (%s)", workerId, event.Event, err.Error())
+			return err
+		}
+
+		// retry the failed put
+		err = p.aws.MessagePutRetry(p.queue, messages, opStatus, 3)
+		if err != nil {
+			log.Printf("[worker %d] ERROR: failed to publish %s event (%s)", workerId, event.Event, err.Error())
+			return err
+		}
+	}
+
+	log.Printf("[worker %d] INFO: published %s event for %s", workerId, event.Event, event.SourceKey)
+	return nil
+}
+
+//
+// end of file
+//
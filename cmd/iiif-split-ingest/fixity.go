@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/uvalib/uva-aws-s3-sdk/uva-s3"
+)
+
+// the fixity sidecars written alongside the outputs of an item
+var fixityJSONName = "fixity.json"
+var fixityManifestName = "manifest-sha256.txt"
+
+// Fixity - the checksums of an output file
+type Fixity struct {
+	Filename string `json:"filename"` // the file name (relative to the sidecar)
+	Size     int64  `json:"size"`     // the file size
+	MD5      string `json:"md5"`      // the MD5 as hex
+	SHA256   string `json:"sha256"`   // the SHA-256 as hex
+}
+
+// FixityRecord - the contents of the JSON fixity sidecar
+type FixityRecord struct {
+	Id      string    `json:"id"`      // the item id
+	Created time.Time `json:"created"` // when the outputs were created
+	Files   []Fixity  `json:"files"`   // the output files in page order
+}
+
+// calculate the fixity of a file written to an output location, returns nil if we are not recording fixity
+func outputFixity(workerId int, config ServiceConfig, filename string, outputName string) (*Fixity, error) {
+
+	if config.Fixity == false {
+		return nil, nil
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	md5sum, sha256sum, err := fileChecksums(filename)
+	if err != nil {
+		log.Printf("[worker %d] ERROR: calculating checksums of %s (%s)", workerId, filename, err.Error())
+		return nil, err
+	}
+
+	return &Fixity{Filename: path.Base(outputName), Size: info.Size(), MD5: md5sum, SHA256: sha256sum}, nil
+}
+
+// write a file to the output bucket, the checksums (if we have them) are stored in the object metadata
+func putOutputFile(workerId int, s3Svc uva_s3.UvaS3, bucket string, key string, filename string, fixity *Fixity) error {
+
+	if fixity == nil {
+		o := uva_s3.NewUvaS3Object(bucket, key)
+		return s3Svc.PutFromFile(o, filename)
+	}
+
+	uploader, err := s3Uploader()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	start := time.Now()
+	_, err = uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   f,
+		Metadata: map[string]*string{
+			sha256MetadataKey: aws.String(fixity.SHA256),
+			md5MetadataKey:    aws.String(fixity.MD5),
+		},
+	})
+	if err != nil {
+		log.Printf("[worker %d] ERROR: uploading %s to s3://%s/%s (%s)", workerId, filename, bucket, key, err.Error())
+		return err
+	}
+
+	duration := time.Since(start)
+	log.Printf("[worker %d] DEBUG: uploaded s3://%s/%s (%d bytes) in %0.2f seconds", workerId, bucket, key, fixity.Size, duration.Seconds())
+	return nil
+}
+
+// write the fixity sidecars for the outputs of an item. There is a set of sidecars in each output directory
+// (the renditions have their own), returns the sidecar locations
+func writeFixitySidecars(workerId int, config ServiceConfig, s3Svc uva_s3.UvaS3, id string, pages []PageResult) ([]string, error) {
+
+	if config.Fixity == false {
+		return nil, nil
+	}
+
+	// collect the fixity of every output by the directory it was written to
+	records := make(map[string]*FixityRecord)
+	locations := make([]string, 0)
+	add := func(output string, fixity *Fixity) {
+		if fixity == nil {
+			return
+		}
+		// the output is either a file or an s3:// URL
+		dir := output[:strings.LastIndex(output, "/")]
+		if _, ok := records[dir]; ok == false {
+			records[dir] = &FixityRecord{Id: id, Created: time.Now().UTC(), Files: make([]Fixity, 0)}
+			locations = append(locations, dir)
+		}
+		records[dir].Files = append(records[dir].Files, *fixity)
+	}
+
+	for _, p := range pages {
+		add(p.Output, p.Fixity)
+		for _, name := range renditionNames(config) {
+			if rendition, ok := p.Renditions[name]; ok == true {
+				add(rendition.Output, rendition.Fixity)
+			}
+		}
+	}
+
+	sidecars := make([]string, 0)
+	for _, dir := range locations {
+		record := records[dir]
+
+		b, err := json.MarshalIndent(record, "", "  ")
+		if err != nil {
+			log.Printf("ERROR: json marshal (%s)", err.Error())
+			return sidecars, err
+		}
+
+		var manifest strings.Builder
+		for _, f := range record.Files {
+			manifest.WriteString(fmt.Sprintf("%s  %s\n", f.SHA256, f.Filename))
+		}
+
+		sidecarNames := []string{fixityJSONName, fixityManifestName}
+		for ix, contents := range []string{string(b) + "\n", manifest.String()} {
+			location := fmt.Sprintf("%s/%s", dir, sidecarNames[ix])
+			if len(config.OutputFSRoot) != 0 {
+				err = writeFile(location, contents)
+			} else {
+				bucket, key, _ := strings.Cut(strings.TrimPrefix(location, "s3://"), "/")
+				err = s3Svc.PutFromBuffer(uva_s3.NewUvaS3Object(bucket, key), []byte(contents))
+			}
+			if err != nil {
+				log.Printf("[worker %d] ERROR: writing fixity sidecar %s (%s)", workerId, location, err.Error())
+				return sidecars, err
+			}
+			sidecars = append(sidecars, location)
+		}
+		log.Printf("[worker %d] INFO: recorded fixity of %d file(s) in %s", workerId, len(record.Files), dir)
+	}
+
+	return sidecars, nil
+}
+
+//
+// end of file
+//
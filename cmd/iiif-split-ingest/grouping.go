@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/uvalib/uva-aws-s3-sdk/uva-s3"
+)
+
+// the suffix of the name used in place of a downloaded file for a group, it is removed when determining the id
+var groupFileSuffix = ".group"
+
+// is this object the marker that indicates a group of page images is complete
+func isGroupMarker(config ServiceConfig, key string) bool {
+	return len(config.GroupMarker) != 0 && path.Base(key) == config.GroupMarker
+}
+
+// when grouping, only the marker objects start a job. The individual page objects are ignored, they are
+// collected when the marker arrives
+func filterGroupMarkers(config ServiceConfig, inbound []InboundFile) []InboundFile {
+
+	if len(config.GroupMarker) == 0 {
+		return inbound
+	}
+
+	markers := make([]InboundFile, 0, len(inbound))
+	for _, f := range inbound {
+		if isGroupMarker(config, f.SourceKey) == true {
+			markers = append(markers, f)
+		} else {
+			log.Printf("[main] DEBUG: ignoring %s/%s, waiting for the group marker", f.SourceBucket, f.SourceKey)
+		}
+	}
+	return markers
+}
+
+// the group id is the name of the "directory" containing the marker
+func groupId(markerKey string) string {
+	return path.Base(path.Dir(markerKey))
+}
+
+// list the page objects of a group, these are the objects alongside the marker (not in any sub-directory)
+func listGroupPages(workerId int, config ServiceConfig, bucket string, markerKey string) ([]BucketObject, error) {
+
+	prefix := fmt.Sprintf("%s/", path.Dir(markerKey))
+	if path.Dir(markerKey) == "." {
+		return nil, fmt.Errorf("group marker %s is not under a prefix", markerKey)
+	}
+
+	objects, err := listBucketObjects(bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	pages := make([]BucketObject, 0, len(objects))
+	for _, o := range objects {
+		name := strings.TrimPrefix(o.Key, prefix)
+		if o.Key == markerKey || strings.Contains(name, "/") == true {
+			continue
+		}
+		if hasPageSuffix(config, name) == false {
+			log.Printf("[worker %d] INFO: ignoring '%s', not a page image", workerId, o.Key)
+			continue
+		}
+		pages = append(pages, o)
+	}
+
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("group %s/%s contains no page images", bucket, prefix)
+	}
+
+	log.Printf("[worker %d] INFO: group %s/%s has %d page(s)", workerId, bucket, prefix, len(pages))
+	return pages, nil
+}
+
+// the total size of the group pages
+func groupSize(pages []BucketObject) int64 {
+	var total int64
+	for _, p := range pages {
+		total += p.Size
+	}
+	return total
+}
+
+// download the group pages and put them in page order, they are renamed so they sort in page order
+func downloadGroupPages(ctx context.Context, workerId int, config ServiceConfig, workDir string, s3Svc uva_s3.UvaS3, bucket string, id string, pages []BucketObject) ([]string, error) {
+
+	// download into a separate directory so the page names cannot collide with the renamed pages
+	downloadDir := fmt.Sprintf("%s/%s.pages", workDir, id)
+	err := createDir(workerId, downloadDir)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(downloadDir)
+
+	downloaded := make([]string, 0, len(pages))
+	for _, p := range pages {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		name, err := downloadS3File(workerId, config, downloadDir, s3Svc, bucket, p.Key, p.Size)
+		if err != nil {
+			return nil, err
+		}
+		downloaded = append(downloaded, name)
+	}
+
+	ordered, err := sortPageNames(config, downloaded)
+	if err != nil {
+		return nil, err
+	}
+
+	pageFiles := make([]string, 0, len(ordered))
+	for ix, name := range ordered {
+		pageName := fmt.Sprintf("%s/%s-%04d%s", workDir, id, ix+1, strings.ToLower(path.Ext(name)))
+		log.Printf("[worker %d] DEBUG: page %d is '%s'", workerId, ix+1, path.Base(name))
+		err = os.Rename(name, pageName)
+		if err != nil {
+			return nil, err
+		}
+		pageFiles = append(pageFiles, pageName)
+	}
+
+	return pageFiles, nil
+}
+
+// remove the group pages and the marker from the bucket
+func deleteGroup(workerId int, s3Svc uva_s3.UvaS3, bucket string, markerKey string, pages []BucketObject) {
+
+	for _, p := range pages {
+		_ = deleteS3File(workerId, s3Svc, bucket, p.Key)
+	}
+	_ = deleteS3File(workerId, s3Svc, bucket, markerKey)
+}
+
+//
+// end of file
+//
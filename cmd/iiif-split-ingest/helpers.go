@@ -1,11 +1,13 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/uvalib/uva-aws-s3-sdk/uva-s3"
 )
@@ -16,22 +18,59 @@ func fatalIfError(err error) {
 	}
 }
 
-// download a file from S3 and return the downloaded filename
-func downloadS3File(workerId int, workDir string, s3Svc uva_s3.UvaS3, bucket string, key string) (string, error) {
+// download a file from S3, verify it and return the downloaded filename. Downloads that fail
+// verification are retried
+func downloadS3File(workerId int, config ServiceConfig, workDir string, s3Svc uva_s3.UvaS3, bucket string, key string, expectedSize int64) (string, error) {
 
 	// create the download filename
 	fileName := path.Base(key)
 	downloadFile := fmt.Sprintf("%s/%s", workDir, fileName)
 
-	// download the file
-	o := uva_s3.NewUvaS3Object(bucket, key)
-	err := s3Svc.GetToFile(o, downloadFile)
+	// what we expect the downloaded file to look like
+	expected := getObjectIntegrity(workerId, bucket, key, expectedSize)
+
+	attempt := 1
+	for {
+		// download the file
+		o := uva_s3.NewUvaS3Object(bucket, key)
+		err := s3Svc.GetToFile(o, downloadFile)
+		if err != nil {
+			log.Printf("[worker %d] ERROR: failed to download %s (%s)", workerId, key, err.Error())
+			return "", err
+		}
+
+		// and make sure we got what we expected
+		err = verifyDownload(downloadFile, expected)
+		if err == nil {
+			return downloadFile, nil
+		}
+
+		_ = os.Remove(downloadFile)
+		if attempt > config.DownloadRetries {
+			log.Printf("[worker %d] ERROR: download of %s failed verification, giving up (%s)", workerId, key, err.Error())
+			// every download had the wrong checksum and the object has not been replaced, it is the source that is bad
+			if errors.Is(err, ErrChecksum) == true && objectChanged(workerId, bucket, key, expected) == false {
+				return "", fmt.Errorf("%w (%s)", ErrCorruptSource, err.Error())
+			}
+			return "", err
+		}
+
+		log.Printf("[worker %d] WARNING: download of %s failed verification, retrying (%s)", workerId, key, err.Error())
+		attempt++
+		time.Sleep(retrySleepTime)
+	}
+}
+
+// copy a local file to the work directory and return the copied filename
+func copyLocalFile(workerId int, workDir string, fileName string) (string, error) {
+
+	workFile := fmt.Sprintf("%s/%s", workDir, path.Base(fileName))
+	err := copyFile(workerId, fileName, workFile)
 	if err != nil {
-		log.Printf("[worker %d] ERROR: failed to download %s (%s)", workerId, key, err.Error())
 		return "", err
 	}
 
-	return downloadFile, nil
+	return workFile, nil
 }
 
 // delete a file from S3
@@ -54,9 +93,7 @@ func generateImageFilenames(workerId int, config ServiceConfig, downloadName str
 	inputBaseNoExt := strings.TrimSuffix(inputBaseName, inputFileExt)
 
 	// we use the original download name for the directory in some cases
-	downloadBaseName := path.Base(downloadName)
-	downloadFileExt := path.Ext(downloadBaseName)
-	downloadBaseNoExt := strings.TrimSuffix(downloadBaseName, downloadFileExt)
+	downloadBaseNoExt := idFromFilename(downloadName)
 
 	// generate new components
 	convertName := fmt.Sprintf("%s/%s.%s", inputDirName, inputBaseNoExt, config.ConvertSuffix)
@@ -79,10 +116,18 @@ func generateManifestFilename(config ServiceConfig, downloadName string) string
 	return fmt.Sprintf("%s/%s", config.ManifestOutputDir, filename)
 }
 
+// archive suffixes made up of more than one extension, these are removed as a whole
+var archiveSuffixes = []string{".tar.gz", ".tar.bz2", ".tgz"}
+
 func idFromFilename(downloadName string) string {
 
 	// we use the original download name for the manifest id
 	downloadBaseName := path.Base(downloadName)
+	for _, suffix := range archiveSuffixes {
+		if len(downloadBaseName) > len(suffix) && strings.HasSuffix(strings.ToLower(downloadBaseName), suffix) == true {
+			return downloadBaseName[:len(downloadBaseName)-len(suffix)]
+		}
+	}
 	downloadFileExt := path.Ext(downloadBaseName)
 	return strings.TrimSuffix(downloadBaseName, downloadFileExt)
 }
@@ -204,6 +249,10 @@ func isLetter(c uint8) bool {
 	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
 }
 
+func isDigit(c uint8) bool {
+	return '0' <= c && c <= '9'
+}
+
 //
 // end of file
 //
@@ -14,6 +14,15 @@ import (
 var maxHttpRetries = 3
 var retrySleepTime = 250 * time.Millisecond
 
+// HttpStatusError - a request that was answered with an unsuccessful status
+type HttpStatusError struct {
+	Status int // the HTTP status
+}
+
+func (e *HttpStatusError) Error() string {
+	return fmt.Sprintf("request returns HTTP %d", e.Status)
+}
+
 func newHttpClient(maxConnections int, timeout int) *http.Client {
 
 	return &http.Client{
@@ -25,9 +34,15 @@ func newHttpClient(maxConnections int, timeout int) *http.Client {
 }
 
 func httpPost(workerId int, url string, client *http.Client, auth string, buffer []byte) ([]byte, error) {
+	return httpPostWithHeaders(workerId, url, client, auth, nil, buffer)
+}
+
+// post with additional request headers
+func httpPostWithHeaders(workerId int, url string, client *http.Client, auth string, headers map[string]string, buffer []byte) ([]byte, error) {
 
 	var response *http.Response
 	count := 0
+	backoff := retrySleepTime
 
 	log.Printf("[worker %d] INFO: post url [%s]", workerId, url)
 	log.Printf("[worker %d] INFO: post payload [%s]", workerId, buffer)
@@ -45,6 +60,10 @@ func httpPost(workerId int, url string, client *http.Client, auth string, buffer
 			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", auth))
 		}
 
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+
 		response, err = client.Do(req)
 		count++
 		if err != nil {
@@ -61,15 +80,15 @@ func httpPost(workerId int, url string, client *http.Client, auth string, buffer
 			log.Printf("[worker %d] WARNING: POST failed with error, retrying (%s)", workerId, err)
 
 			// sleep for a bit before retrying
-			time.Sleep(retrySleepTime)
+			time.Sleep(backoff)
+			backoff *= 2
 		} else {
 
-			defer response.Body.Close()
-
 			body, err := io.ReadAll(response.Body)
+			response.Body.Close()
 
 			// happy day, hopefully all is well
-			if response.StatusCode == http.StatusOK {
+			if response.StatusCode >= http.StatusOK && response.StatusCode < http.StatusMultipleChoices {
 
 				// if the body read failed
 				if err != nil {
@@ -81,12 +100,25 @@ func httpPost(workerId int, url string, client *http.Client, auth string, buffer
 				return body, nil
 			}
 
+			// the receiver is overloaded or having problems, it may recover
+			if canRetryStatus(response.StatusCode) == true && count < maxHttpRetries {
+				log.Printf("[worker %d] WARNING: POST failed with status %d, retrying", workerId, response.StatusCode)
+				time.Sleep(backoff)
+				backoff *= 2
+				continue
+			}
+
 			log.Printf("[worker %d] ERROR POST failed with status %d (%s)", workerId, response.StatusCode, body)
-			return body, fmt.Errorf("request returns HTTP %d", response.StatusCode)
+			return body, &HttpStatusError{Status: response.StatusCode}
 		}
 	}
 }
 
+// examines the response status and decides if the request can be retried
+func canRetryStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
 // examines the error and decides if it can be retried
 func canRetry(err error) bool {
 
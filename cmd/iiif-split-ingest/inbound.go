@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/uvalib/virgo4-sqs-sdk/awssqs"
 	"log"
 	"net/url"
+	"sync"
 	"time"
 )
 
@@ -12,11 +15,105 @@ type InboundFile struct {
 	SourceBucket string
 	SourceKey    string
 	ObjectSize   int64
+	ETag         string
 }
 
-func getInboundNotification(config ServiceConfig, aws awssqs.AWS_SQS, inQueueHandle awssqs.QueueHandle) (*InboundFile, awssqs.ReceiptHandle, error) {
+// InboundMessage - tracks the objects referenced by a single inbound message so that the message is
+// only deleted once all of them have been processed. The message is kept invisible from the time it is
+// received until then, including while its objects are waiting for a worker
+type InboundMessage struct {
+	ReceiptHandle awssqs.ReceiptHandle // the inbound message receipt handle
+	outstanding   int                  // the number of objects not yet processed
+	failed        bool                 // did any of the objects fail to process
+	abandoned     bool                 // were any of the objects not processed because we are shutting down
+	skipped       bool                 // were any of the objects not processed because another job has them
+	visibility    *VisibilityExtender  // used to extend and release the message visibility
+	stopHeartbeat func()               // stops the visibility heartbeat
+	sync.Mutex
+}
+
+func newInboundMessage(receiptHandle awssqs.ReceiptHandle, objectCount int, visibility *VisibilityExtender) *InboundMessage {
+	return &InboundMessage{
+		ReceiptHandle: receiptHandle,
+		outstanding:   objectCount,
+		visibility:    visibility,
+		stopHeartbeat: visibility.start(receiptHandle),
+	}
+}
+
+// complete marks one of the message objects as processed and returns true when all of the
+// objects have been processed successfully and the message can be deleted
+func (m *InboundMessage) complete(success bool) bool {
+	if m == nil {
+		return false
+	}
+	m.Lock()
+	defer m.Unlock()
+
+	if success == false {
+		m.failed = true
+	}
+
+	// are there still objects being processed
+	if m.finish() == false {
+		return false
+	}
+
+	if m.failed == true || m.abandoned == true || m.skipped == true {
+		log.Printf("[main] WARNING: one or more objects failed or were not processed, leaving message for redelivery")
+		return false
+	}
+	return true
+}
+
+// skip marks one of the message objects as not processed because another job is already processing it.
+// The message is not released, it is redelivered once its visibility expires
+func (m *InboundMessage) skip() {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+
+	m.skipped = true
+	if m.finish() == true && m.failed == false && m.abandoned == false {
+		log.Printf("[main] INFO: leaving message for redelivery once its visibility expires")
+	}
+}
 
-	for {
+// abandon marks one of the message objects as not processed (because we are shutting down). Once all the
+// objects are accounted for the message is made visible again so it is redelivered promptly
+func (m *InboundMessage) abandon() {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+
+	m.abandoned = true
+	m.finish()
+}
+
+// account for one of the message objects, returns true if it was the last one. The heartbeat is stopped
+// once all the objects are accounted for and the message released if any were abandoned
+func (m *InboundMessage) finish() bool {
+
+	m.outstanding--
+	if m.outstanding > 0 {
+		return false
+	}
+
+	m.stopHeartbeat()
+	if m.abandoned == true {
+		m.visibility.release(m.ReceiptHandle)
+	}
+	return true
+}
+
+// wait for the next inbound notification, returns an empty list if we are asked to stop
+func getInboundNotification(ctx context.Context, config ServiceConfig, aws awssqs.AWS_SQS, inQueueHandle awssqs.QueueHandle, deadQueueHandle awssqs.QueueHandle) ([]InboundFile, awssqs.ReceiptHandle) {
+
+	for ctx.Err() == nil {
 
 		// get the next message if one is available
 		messages, err := aws.BatchMessageGet(inQueueHandle, 1, time.Duration(config.PollTimeOut)*time.Second)
@@ -33,51 +130,150 @@ func getInboundNotification(config ServiceConfig, aws awssqs.AWS_SQS, inQueueHan
 		// did we get anything to process
 		if len(messages) == 1 {
 
+			// we are shutting down, leave the message for redelivery
+			if ctx.Err() != nil {
+				log.Printf("[main] INFO: shutting down, ignoring new notification")
+				break
+			}
+
 			log.Printf("[main] INFO: received a new notification")
 
 			//log.Printf("%s", string( messages[0].Payload ) )
 
-			// assume the message is an S3 event containing a list of one or more new objects
-			newS3objects, err := decodeS3Event(messages[0])
+			// decode the message, anything we cannot make sense of gets quarantined
+			inboundFiles, err := decodeInboundMessage(messages[0])
 			if err != nil {
-				return nil, "", err
+				_ = quarantineMessage(config, aws, inQueueHandle, deadQueueHandle, messages[0], err.Error())
+				continue
 			}
 
-			// we have an object to download
-			if len(newS3objects) == 1 {
-
-				// some file names may be HTML encoded... un-encode them here...
-				key, err := url.QueryUnescape(newS3objects[0].S3.Object.Key)
-				if err != nil {
-					return nil, "", err
-				}
+			// when grouping, only the group markers are interesting
+			inboundFiles = filterGroupMarkers(config, inboundFiles)
 
-				inboundFile := InboundFile{
-					SourceBucket: newS3objects[0].S3.Bucket.Name,
-					SourceKey:    key,
-					ObjectSize:   newS3objects[0].S3.Object.Size}
-
-				return &inboundFile, messages[0].ReceiptHandle, nil
+			// we have one or more objects to download
+			if len(inboundFiles) != 0 {
+				log.Printf("[main] INFO: notification contains %d object(s)", len(inboundFiles))
+				return inboundFiles, messages[0].ReceiptHandle
 			} else {
-				log.Printf("[main] WARNING: not an interesting notification, ignoring it")
+				log.Printf("[main] INFO: not an interesting notification, ignoring it")
+				_ = deleteInboundMessage(aws, inQueueHandle, messages[0].ReceiptHandle)
 			}
 
 		} else {
 			log.Printf("[main] INFO: no new notifications...")
 		}
 	}
+
+	return nil, ""
 }
 
-// turn a message received from the inbound queue into a list of zero or more new S3 objects
-func decodeS3Event(message awssqs.Message) ([]S3EventRecord, error) {
+// turn a message received from the inbound queue into a list of zero or more validated inbound files
+func decodeInboundMessage(message awssqs.Message) ([]InboundFile, error) {
+
+	// the message payload could not be retrieved completely
+	if message.Incomplete == true {
+		return nil, fmt.Errorf("message payload is incomplete")
+	}
 
-	events := Events{}
-	err := json.Unmarshal([]byte(message.Payload), &events)
+	return decodeInboundPayload(message.Payload)
+}
+
+// decode a payload, unwrapping any SNS or EventBridge envelope to get at the S3 object details
+func decodeInboundPayload(payload []byte) ([]InboundFile, error) {
+
+	envelope := InboundEnvelope{}
+	err := json.Unmarshal(payload, &envelope)
 	if err != nil {
 		log.Printf("[main] ERROR: json unmarshal: %s", err)
-		return nil, err
+		return nil, fmt.Errorf("cannot decode message (%s)", err.Error())
+	}
+
+	switch {
+
+	// an S3 event, possibly containing several records
+	case len(envelope.Records) != 0:
+		return decodeS3Event(envelope.Records)
+
+	// the S3 test event, nothing to do
+	case envelope.Event == s3TestEventName:
+		log.Printf("[main] INFO: received S3 test event")
+		return nil, nil
+
+	// an SNS envelope, the message is the wrapped payload
+	case len(envelope.Type) != 0:
+		if envelope.Type != snsNotificationType {
+			log.Printf("[main] INFO: received SNS %s message", envelope.Type)
+			return nil, nil
+		}
+		return decodeInboundPayload([]byte(envelope.Message))
+
+	// an EventBridge event
+	case envelope.Detail != nil:
+		if envelope.Source != eventBridgeS3Source {
+			return nil, fmt.Errorf("unexpected EventBridge event source [%s]", envelope.Source)
+		}
+		if envelope.DetailType != eventBridgeObjectCreated {
+			log.Printf("[main] INFO: received EventBridge %s event", envelope.DetailType)
+			return nil, nil
+		}
+		return decodeEventBridgeEvent(*envelope.Detail)
+	}
+
+	return nil, fmt.Errorf("unrecognized message format")
+}
+
+// turn the records of an S3 event into a list of inbound files
+func decodeS3Event(records []S3EventRecord) ([]InboundFile, error) {
+
+	inboundFiles := make([]InboundFile, 0, len(records))
+	for ix, obj := range records {
+
+		// some file names may be HTML encoded... un-encode them here...
+		key, err := url.QueryUnescape(obj.S3.Object.Key)
+		if err != nil {
+			return nil, fmt.Errorf("cannot unescape key for record %d (%s)", ix, err.Error())
+		}
+
+		if len(obj.S3.Bucket.Name) == 0 || len(key) == 0 {
+			return nil, fmt.Errorf("record %d is missing a bucket name or object key", ix)
+		}
+
+		inboundFiles = append(inboundFiles, InboundFile{
+			SourceBucket: obj.S3.Bucket.Name,
+			SourceKey:    key,
+			ObjectSize:   obj.S3.Object.Size,
+			ETag:         obj.S3.Object.ETag})
+	}
+
+	return inboundFiles, nil
+}
+
+// turn an EventBridge event into an inbound file (EventBridge object keys are not encoded)
+func decodeEventBridgeEvent(detail EventBridgeDetail) ([]InboundFile, error) {
+
+	if len(detail.Bucket.Name) == 0 || len(detail.Object.Key) == 0 {
+		return nil, fmt.Errorf("event is missing a bucket name or object key")
+	}
+
+	inboundFile := InboundFile{
+		SourceBucket: detail.Bucket.Name,
+		SourceKey:    detail.Object.Key,
+		ObjectSize:   detail.Object.Size,
+		ETag:         detail.Object.ETag}
+
+	return []InboundFile{inboundFile}, nil
+}
+
+// delete a message from the inbound queue
+func deleteInboundMessage(aws awssqs.AWS_SQS, inQueueHandle awssqs.QueueHandle, receiptHandle awssqs.ReceiptHandle) error {
+
+	delMessages := []awssqs.Message{{ReceiptHandle: receiptHandle}}
+	_, err := aws.BatchMessageDelete(inQueueHandle, delMessages)
+	if err != nil {
+		log.Printf("[main] WARNING: failed to delete inbound message (%s)", err.Error())
+		return err
 	}
-	return events.Records, nil
+	return nil
 }
 
 //
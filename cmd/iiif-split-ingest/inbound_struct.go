@@ -2,10 +2,6 @@ package main
 
 // this describes the structure of the event received from S3
 
-type Events struct {
-	Records []S3EventRecord `json:"Records"`
-}
-
 type S3EventRecord struct {
 	S3 S3Record `json:"S3"`
 }
@@ -22,8 +18,40 @@ type BucketRecord struct {
 type ObjectRecord struct {
 	Key  string `json:"key"`
 	Size int64  `json:"size"`
+	ETag string `json:"eTag"`
 }
 
+// this describes the other message formats that may wrap (or replace) the S3 event
+
+// InboundEnvelope - the union of the fields we use to identify the format of an inbound message
+type InboundEnvelope struct {
+	// raw S3 event
+	Records []S3EventRecord `json:"Records"`
+
+	// S3 test event (sent when the bucket notification is configured)
+	Event string `json:"Event"`
+
+	// SNS envelope
+	Type    string `json:"Type"`
+	Message string `json:"Message"`
+
+	// EventBridge event
+	Source     string             `json:"source"`
+	DetailType string             `json:"detail-type"`
+	Detail     *EventBridgeDetail `json:"detail"`
+}
+
+type EventBridgeDetail struct {
+	Bucket BucketRecord `json:"bucket"`
+	Object ObjectRecord `json:"object"`
+}
+
+// the values we use to identify the message formats
+var s3TestEventName = "s3:TestEvent"
+var snsNotificationType = "Notification"
+var eventBridgeS3Source = "aws.s3"
+var eventBridgeObjectCreated = "Object Created"
+
 //
 // end of file
 //
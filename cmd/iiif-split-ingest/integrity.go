@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// ErrIntegrity - a downloaded file does not match the source object
+var ErrIntegrity = fmt.Errorf("integrity check failed")
+
+// ErrChecksum - a downloaded file has the expected size but not the expected checksum
+var ErrChecksum = fmt.Errorf("%w: checksum mismatch", ErrIntegrity)
+
+// ErrCorruptSource - the source object does not match its own checksum, downloading it again will not help
+var ErrCorruptSource = fmt.Errorf("source object is corrupt")
+
+// the object metadata keys that may hold a checksum of the object contents
+var sha256MetadataKey = "sha256"
+var md5MetadataKey = "md5"
+
+// an ETag is only the MD5 of the contents when the object was not uploaded in parts
+var md5ETagPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// ObjectIntegrity - what we expect a downloaded file to look like
+type ObjectIntegrity struct {
+	Size   int64  // the expected size (or -1 if unknown)
+	MD5    string // the expected MD5 as hex (if known)
+	SHA256 string // the expected SHA-256 as hex (if known)
+	ETag   string // the object ETag (if known), used to tell if the object has changed
+}
+
+// determine what we can about the object so the download can be verified. We prefer the current object
+// attributes but fall back to the size from the notification if we cannot get them
+func getObjectIntegrity(workerId int, bucket string, key string, expectedSize int64) ObjectIntegrity {
+
+	integrity := ObjectIntegrity{Size: -1}
+	if expectedSize > 0 {
+		integrity.Size = expectedSize
+	}
+
+	head, err := headS3Object(bucket, key)
+	if err != nil {
+		log.Printf("[worker %d] WARNING: getting attributes of %s/%s (%s), verifying size only", workerId, bucket, key, err.Error())
+		return integrity
+	}
+
+	integrity.Size = aws.Int64Value(head.ContentLength)
+	integrity.ETag = normalizeETag(aws.StringValue(head.ETag))
+
+	// a checksum in the object metadata takes precedence over the ETag
+	for k, v := range head.Metadata {
+		switch strings.ToLower(k) {
+		case sha256MetadataKey:
+			integrity.SHA256 = strings.ToLower(aws.StringValue(v))
+		case md5MetadataKey:
+			integrity.MD5 = strings.ToLower(aws.StringValue(v))
+		}
+	}
+
+	// the ETag of an object encrypted with SSE-KMS or SSE-C is not the MD5 of the contents
+	if encryptedETag(head) == true {
+		log.Printf("[worker %d] DEBUG: %s/%s is KMS or customer key encrypted, not using the ETag", workerId, bucket, key)
+		return integrity
+	}
+
+	etag := strings.ToLower(integrity.ETag)
+	if len(integrity.MD5) == 0 && md5ETagPattern.MatchString(etag) == true {
+		integrity.MD5 = etag
+	}
+
+	return integrity
+}
+
+// is the object encrypted in a way that means the ETag is not the MD5 of the contents
+func encryptedETag(head *s3.HeadObjectOutput) bool {
+
+	sse := aws.StringValue(head.ServerSideEncryption)
+	return sse == s3.ServerSideEncryptionAwsKms || sse == s3.ServerSideEncryptionAwsKmsDsse ||
+		len(aws.StringValue(head.SSECustomerAlgorithm)) != 0
+}
+
+// verify the downloaded file matches what we expect
+func verifyDownload(filename string, expected ObjectIntegrity) error {
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	if expected.Size >= 0 && info.Size() != expected.Size {
+		return fmt.Errorf("%w: %s is %d bytes, expected %d", ErrIntegrity, filename, info.Size(), expected.Size)
+	}
+
+	// nothing more we can check
+	if len(expected.MD5) == 0 && len(expected.SHA256) == 0 {
+		return nil
+	}
+
+	md5sum, sha256sum, err := fileChecksums(filename)
+	if err != nil {
+		return err
+	}
+
+	if len(expected.SHA256) != 0 && sha256sum != expected.SHA256 {
+		return fmt.Errorf("%w: %s SHA-256 is %s, expected %s", ErrChecksum, filename, sha256sum, expected.SHA256)
+	}
+
+	if len(expected.MD5) != 0 && md5sum != expected.MD5 {
+		return fmt.Errorf("%w: %s MD5 is %s, expected %s", ErrChecksum, filename, md5sum, expected.MD5)
+	}
+
+	return nil
+}
+
+// has the object changed since we determined the expected integrity, we assume it has if we cannot tell
+func objectChanged(workerId int, bucket string, key string, expected ObjectIntegrity) bool {
+
+	if len(expected.ETag) == 0 {
+		return true
+	}
+
+	head, err := headS3Object(bucket, key)
+	if err != nil {
+		log.Printf("[worker %d] WARNING: getting attributes of %s/%s (%s)", workerId, bucket, key, err.Error())
+		return true
+	}
+	return normalizeETag(aws.StringValue(head.ETag)) != expected.ETag
+}
+
+// calculate the MD5 and SHA-256 of a file (as hex)
+func fileChecksums(filename string) (string, string, error) {
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
+	_, md5sum, sha256sum, err := readerChecksums(f)
+	return md5sum, sha256sum, err
+}
+
+// calculate the size, MD5 and SHA-256 (as hex) of everything read from the reader
+func readerChecksums(r io.Reader) (int64, string, string, error) {
+
+	md5hash := md5.New()
+	sha256hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(md5hash, sha256hash), r)
+	if err != nil {
+		return n, "", "", err
+	}
+
+	return n, hex.EncodeToString(md5hash.Sum(nil)), hex.EncodeToString(sha256hash.Sum(nil)), nil
+}
+
+//
+// end of file
+//
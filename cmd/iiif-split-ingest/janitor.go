@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// the work directories currently owned by live jobs (and the number of jobs using each)
+var liveWorkDirs = struct {
+	sync.Mutex
+	dirs map[string]int
+}{dirs: make(map[string]int)}
+
+// mark a work directory as owned by a live job
+func claimWorkDir(workDir string) {
+	liveWorkDirs.Lock()
+	defer liveWorkDirs.Unlock()
+	liveWorkDirs.dirs[filepath.Clean(workDir)]++
+}
+
+// the job owning the work directory is done with it
+func releaseWorkDir(workDir string) {
+	liveWorkDirs.Lock()
+	defer liveWorkDirs.Unlock()
+	dir := filepath.Clean(workDir)
+	liveWorkDirs.dirs[dir]--
+	if liveWorkDirs.dirs[dir] <= 0 {
+		delete(liveWorkDirs.dirs, dir)
+	}
+}
+
+func isLiveWorkDir(workDir string) bool {
+	liveWorkDirs.Lock()
+	defer liveWorkDirs.Unlock()
+	return liveWorkDirs.dirs[filepath.Clean(workDir)] > 0
+}
+
+// remove a work directory unless a job has claimed it, returns true if it was removed. The lock is held while
+// removing so a job cannot claim the directory part way through
+func removeUnclaimedWorkDir(workDir string) (bool, error) {
+	liveWorkDirs.Lock()
+	defer liveWorkDirs.Unlock()
+	if liveWorkDirs.dirs[filepath.Clean(workDir)] > 0 {
+		return false, nil
+	}
+	return true, os.RemoveAll(workDir)
+}
+
+// clean up orphaned work directories now and then periodically (if configured) until we are asked to stop
+func startJanitor(ctx context.Context, config ServiceConfig) {
+
+	cleanupWorkDirs(config)
+
+	if config.JanitorInterval == 0 {
+		return
+	}
+
+	go func() {
+		for sleepUnlessStopped(ctx, time.Duration(config.JanitorInterval)*time.Second) == true {
+			cleanupWorkDirs(config)
+		}
+	}()
+}
+
+// remove any work directories not owned by a live job that have not been modified within the age threshold
+func cleanupWorkDirs(config ServiceConfig) {
+
+	entries, err := os.ReadDir(config.LocalWorkDir)
+	if err != nil {
+		log.Printf("[janitor] ERROR: listing work directory %s (%s)", config.LocalWorkDir, err.Error())
+		return
+	}
+
+	threshold := time.Now().Add(-time.Duration(config.JanitorAge) * time.Second)
+	removed := 0
+	var reclaimed int64
+	for _, e := range entries {
+		if e.IsDir() == false {
+			continue
+		}
+
+		dir := fmt.Sprintf("%s/%s", config.LocalWorkDir, e.Name())
+		if isLiveWorkDir(dir) == true {
+			continue
+		}
+
+		// use the most recent modification of anything in the tree
+		size, modified := treeAttributes(dir)
+		if modified.After(threshold) == true {
+			continue
+		}
+
+		// a job may have claimed it while we were looking
+		removedDir, err := removeUnclaimedWorkDir(dir)
+		if err != nil {
+			log.Printf("[janitor] WARNING: removing %s (%s)", dir, err.Error())
+			continue
+		}
+		if removedDir == false {
+			continue
+		}
+		log.Printf("[janitor] INFO: removed orphaned work directory %s (%d bytes, last modified %s)", dir, size, modified.Format(time.RFC3339))
+		removed++
+		reclaimed += size
+	}
+
+	if removed != 0 {
+		log.Printf("[janitor] INFO: removed %d orphaned work directories, reclaimed %d bytes", removed, reclaimed)
+	}
+}
+
+// get the total size and most recent modification time of a directory tree
+func treeAttributes(dir string) (int64, time.Time) {
+
+	var size int64
+	var modified time.Time
+	_ = filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() == false {
+			size += info.Size()
+		}
+		if info.ModTime().After(modified) == true {
+			modified = info.ModTime()
+		}
+		return nil
+	})
+
+	return size, modified
+}
+
+//
+// end of file
+//
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrJobInProgress - another job is already working on the same object (a duplicate notification)
+var ErrJobInProgress = fmt.Errorf("job already in progress")
+
+// Journal - the persistent record of the stages completed for a job so an interrupted job can be
+// resumed where it stopped rather than starting over
+type Journal struct {
+	Key        string                `json:"key"`        // the job key (bucket/key/etag)
+	WorkDir    string                `json:"work_dir"`   // the job work directory
+	Downloaded string                `json:"downloaded"` // the downloaded file (once downloaded)
+	Split      bool                  `json:"split"`      // has the split stage completed
+	Pages      []string              `json:"pages"`      // the pages to be converted (once split)
+	Converted  map[string]PageResult `json:"converted"`  // the pages converted and output, keyed by page
+	Manifest   bool                  `json:"manifest"`   // has the manifest stage completed
+	Updated    time.Time             `json:"updated"`    // when the journal was last updated
+
+	filename string   // the journal file
+	lock     *os.File // the exclusive lock held while the job is running
+	sync.Mutex
+}
+
+// the key used to identify a job, changes if the source object is replaced
+func jobKey(notify Notify) string {
+
+	// local files do not have an ETag so use the size and modification time instead
+	if len(notify.LocalFile) != 0 {
+		info, err := os.Stat(notify.LocalFile)
+		if err == nil {
+			return fmt.Sprintf("%s/%d/%d", notify.LocalFile, info.Size(), info.ModTime().UnixNano())
+		}
+		return notify.LocalFile
+	}
+	return fmt.Sprintf("%s/%s/%s", notify.SourceBucket, notify.BucketKey, notify.ETag)
+}
+
+// a filesystem safe name derived from the job key
+func jobHash(key string) string {
+	h := sha1.Sum([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// the work directory of a journaled job, it is derived from the key so it can be found again
+func journalWorkDir(config ServiceConfig, key string) string {
+	return fmt.Sprintf("%s/job-%s", config.LocalWorkDir, jobHash(key)[:16])
+}
+
+// load the journal for a job (or create a new one), returns nil if journaling is not configured
+func loadJournal(workerId int, config ServiceConfig, key string) (*Journal, error) {
+
+	if len(config.JournalDir) == 0 {
+		return nil, nil
+	}
+
+	hash := jobHash(key)
+	filename := fmt.Sprintf("%s/%s.json", config.JournalDir, hash)
+
+	// duplicate notifications for the same object would share the work directory, only one can run at a time
+	lock, err := lockJournal(filename)
+	if err != nil {
+		if err == ErrJobInProgress {
+			log.Printf("[worker %d] WARNING: %s is already being processed", workerId, key)
+		} else {
+			log.Printf("[worker %d] ERROR: locking journal %s (%s)", workerId, filename, err.Error())
+		}
+		return nil, err
+	}
+
+	j := &Journal{}
+	b, err := os.ReadFile(filename)
+	if err == nil {
+		err = json.Unmarshal(b, j)
+		if err == nil && j.Key == key {
+			log.Printf("[worker %d] INFO: resuming job from journal %s (%d page(s) complete)", workerId, filename, len(j.Converted))
+			j.filename = filename
+			j.lock = lock
+			// the work directory may have been removed by the janitor
+			err = createDir(workerId, j.WorkDir)
+			if err != nil {
+				j.unlock()
+				return nil, err
+			}
+			return j, nil
+		}
+		log.Printf("[worker %d] WARNING: ignoring unusable journal %s", workerId, filename)
+	}
+
+	// a new job
+	j = &Journal{
+		Key:       key,
+		WorkDir:   journalWorkDir(config, key),
+		Converted: make(map[string]PageResult),
+		filename:  filename,
+		lock:      lock,
+	}
+
+	err = createDir(workerId, j.WorkDir)
+	if err == nil {
+		err = j.save()
+	}
+	if err != nil {
+		j.unlock()
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// take the exclusive lock for a journal, returns ErrJobInProgress if someone else holds it. The lock is
+// released by the system if we die so it never needs to be cleaned up
+func lockJournal(filename string) (*os.File, error) {
+
+	lockName := fmt.Sprintf("%s.lock", filename)
+	for {
+		f, err := os.OpenFile(lockName, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, err
+		}
+
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err != nil {
+			f.Close()
+			if err == syscall.EWOULDBLOCK {
+				return nil, ErrJobInProgress
+			}
+			return nil, err
+		}
+
+		// the previous holder removes the lock file when its job completes, if that happened after we
+		// opened it we hold a lock nobody else can see so try again
+		held, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		current, err := os.Stat(lockName)
+		if err == nil && os.SameFile(held, current) == true {
+			return f, nil
+		}
+		f.Close()
+	}
+}
+
+// release the journal lock, the job may be resumed by someone else
+func (j *Journal) unlock() {
+	if j == nil || j.lock == nil {
+		return
+	}
+	_ = j.lock.Close()
+	j.lock = nil
+}
+
+// update the journal on disk, failures are logged but do not fail the job (we just cannot resume it)
+func (j *Journal) update() {
+	err := j.save()
+	if err != nil {
+		log.Printf("WARNING: updating journal %s (%s)", j.filename, err.Error())
+	}
+}
+
+// write the journal to disk, we write a temp file and rename it so the journal is never partially written
+func (j *Journal) save() error {
+
+	j.Updated = time.Now()
+	b, err := json.Marshal(j)
+	if err != nil {
+		log.Printf("ERROR: json marshal (%s)", err.Error())
+		return err
+	}
+
+	tmpName := fmt.Sprintf("%s.tmp", j.filename)
+	err = writeFile(tmpName, string(b))
+	if err != nil {
+		log.Printf("ERROR: writing %s (%s)", tmpName, err.Error())
+		return err
+	}
+
+	return os.Rename(tmpName, j.filename)
+}
+
+// record that the file has been downloaded
+func (j *Journal) recordDownload(downloadedName string) {
+	if j == nil {
+		return
+	}
+	j.Lock()
+	defer j.Unlock()
+	j.Downloaded = downloadedName
+	j.update()
+}
+
+// the downloaded file (if we have one from an earlier attempt)
+func (j *Journal) downloaded() string {
+	if j == nil || len(j.Downloaded) == 0 || fileExists(j.Downloaded) == false {
+		return ""
+	}
+	return j.Downloaded
+}
+
+// record the pages to be converted
+func (j *Journal) recordSplit(pages []string) {
+	if j == nil {
+		return
+	}
+	j.Lock()
+	defer j.Unlock()
+	j.Split = true
+	j.Pages = pages
+	j.update()
+}
+
+// the pages to be converted (if we have them from an earlier attempt)
+func (j *Journal) pages() []string {
+	if j == nil || j.Split == false {
+		return nil
+	}
+	for _, p := range j.Pages {
+		if fileExists(p) == false {
+			return nil
+		}
+	}
+	return j.Pages
+}
+
+// record that a page has been converted and output
+func (j *Journal) recordPage(inputName string, result PageResult) {
+	if j == nil {
+		return
+	}
+	j.Lock()
+	defer j.Unlock()
+	j.Converted[inputName] = result
+	j.update()
+}
+
+// the result of converting a page (if we have it from an earlier attempt)
+func (j *Journal) page(inputName string) *PageResult {
+	if j == nil {
+		return nil
+	}
+	j.Lock()
+	defer j.Unlock()
+	result, found := j.Converted[inputName]
+	// the target file is needed to generate the manifest
+	if found == false || fileExists(result.TargetFile) == false {
+		return nil
+	}
+	return &result
+}
+
+// record that the manifest has been created
+func (j *Journal) recordManifest() {
+	if j == nil {
+		return
+	}
+	j.Lock()
+	defer j.Unlock()
+	j.Manifest = true
+	j.update()
+}
+
+// has the manifest been created by an earlier attempt
+func (j *Journal) manifestDone() bool {
+	return j != nil && j.Manifest == true
+}
+
+// the job is complete, remove the journal
+func (j *Journal) remove() {
+	if j == nil {
+		return
+	}
+	err := os.Remove(j.filename)
+	if err != nil {
+		log.Printf("WARNING: removing journal %s (%s)", j.filename, err.Error())
+	}
+
+	// the lock file is removed while we still hold the lock so nobody else can be using it
+	if j.lock != nil {
+		_ = os.Remove(j.lock.Name())
+	}
+}
+
+//
+// end of file
+//
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/uvalib/uva-aws-s3-sdk/uva-s3"
 	"github.com/uvalib/virgo4-sqs-sdk/awssqs"
@@ -13,8 +17,26 @@ func main() {
 
 	log.Printf("[main] ===> %s service staring up (version: %s) <===", os.Args[0], Version())
 
+	// are we running one of the command line modes
+	handled, exitCode := runCommandLineMode(os.Args[1:])
+	if handled == true {
+		os.Exit(exitCode)
+	}
+
 	// Get config params and use them to init service context. Any issues are fatal
-	cfg := LoadConfiguration()
+	cfg := LoadConfiguration(true)
+
+	// stopCtx is cancelled when we are asked to shut down, jobCtx when in-flight jobs must be abandoned
+	stopCtx, stopSignals := newShutdownContext()
+	defer stopSignals()
+	jobCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
+
+	// are we watching a local directory rather than an inbound queue
+	if len(cfg.WatchDir) != 0 {
+		runWatchMode(stopCtx, jobCtx, cancelJobs, *cfg)
+		return
+	}
 
 	// load our AWS sqs helper object
 	aws, err := awssqs.NewAwsSqs(awssqs.AwsSqsConfig{MessageBucketName: " "})
@@ -24,34 +46,125 @@ func main() {
 	s3Svc, err := uva_s3.NewUvaS3(uva_s3.UvaS3Config{Logging: true})
 	fatalIfError(err)
 
+	// and the clients we use for the things it does not support
+	fatalIfError(initAwsClients())
+
 	// get the queue handles from the queue name
 	inQueueHandle, err := aws.QueueHandle(cfg.InQueueName)
 	fatalIfError(err)
 
+	// and the dead letter queue if one is configured
+	var deadQueueHandle awssqs.QueueHandle
+	if len(cfg.DeadLetterQueueName) != 0 {
+		deadQueueHandle, err = aws.QueueHandle(cfg.DeadLetterQueueName)
+		fatalIfError(err)
+	}
+
+	// remove anything left behind by an earlier instance
+	startJanitor(stopCtx, *cfg)
+
+	// used to extend the visibility of messages while they are being processed
+	visibility, err := newVisibilityExtender(*cfg, inQueueHandle)
+	fatalIfError(err)
+
+	// used to publish completion events (if configured)
+	events, err := newEventPublisher(aws, cfg.OutQueueName)
+	fatalIfError(err)
+	webhooks := newWebhookNotifier(*cfg)
+
 	// create the notification channel
 	notifyChan := make(chan Notify, cfg.WorkerQueueSize)
 
 	// start workers here
+	var workers sync.WaitGroup
 	for w := 1; w <= cfg.Workers; w++ {
-		go worker(w, *cfg, aws, s3Svc, inQueueHandle, notifyChan)
+		workers.Add(1)
+		go func(workerId int) {
+			defer workers.Done()
+			worker(stopCtx, jobCtx, workerId, *cfg, aws, s3Svc, inQueueHandle, deadQueueHandle, events, webhooks, notifyChan)
+		}(w)
 	}
 
-	for {
+	for stopCtx.Err() == nil {
 		// notification that there is one or more new ingest files to be processed
-		inbound, receiptHandle, err := getInboundNotification(*cfg, aws, inQueueHandle)
-		fatalIfError(err)
+		inbound, receiptHandle := getInboundNotification(stopCtx, *cfg, aws, inQueueHandle, deadQueueHandle)
+		if len(inbound) == 0 {
+			continue
+		}
+
+		// track the objects in this message so it is only deleted once they have all been processed
+		message := newInboundMessage(receiptHandle, len(inbound), visibility)
 
-		// create the notification structure and send to the worker queue
-		notify := Notify{
-			SourceBucket:  inbound.SourceBucket,
-			BucketKey:     inbound.SourceKey,
-			ExpectedSize:  inbound.ObjectSize,
-			ReceiptHandle: receiptHandle,
+		// create a notification structure for each object and send to the worker queue
+		for _, f := range inbound {
+			notify := Notify{
+				SourceBucket:  f.SourceBucket,
+				BucketKey:     f.SourceKey,
+				ExpectedSize:  f.ObjectSize,
+				ETag:          f.ETag,
+				ReceiptHandle: receiptHandle,
+				Message:       message,
+			}
+			select {
+			case notifyChan <- notify:
+			case <-stopCtx.Done():
+				// never handed to a worker
+				message.abandon()
+			}
 		}
-		notifyChan <- notify
 	}
 
-	// should never get here
+	// no more notifications, wait for the workers to finish up and deliver any outstanding webhooks
+	// within the grace period
+	deadline := time.Now().Add(time.Duration(cfg.ShutdownGracePeriod) * time.Second)
+	close(notifyChan)
+	drainWorkers(*cfg, &workers, cancelJobs)
+	webhooks.stop(deadline)
+	log.Printf("[main] ===> %s service shut down <===", os.Args[0])
+}
+
+// process files dropped into a local directory, no AWS services are required unless we are publishing
+// completion events
+func runWatchMode(stopCtx context.Context, jobCtx context.Context, cancelJobs context.CancelFunc, cfg ServiceConfig) {
+
+	// create the done and failed directories
+	fatalIfError(os.MkdirAll(fmt.Sprintf("%s/%s", cfg.WatchDir, watchDoneDir), 0755))
+	fatalIfError(os.MkdirAll(fmt.Sprintf("%s/%s", cfg.WatchDir, watchFailedDir), 0755))
+
+	// remove anything left behind by an earlier instance
+	startJanitor(stopCtx, cfg)
+
+	// completion events are the only AWS service we may need
+	var events *EventPublisher
+	if len(cfg.OutQueueName) != 0 {
+		aws, err := awssqs.NewAwsSqs(awssqs.AwsSqsConfig{MessageBucketName: " "})
+		fatalIfError(err)
+		events, err = newEventPublisher(aws, cfg.OutQueueName)
+		fatalIfError(err)
+	}
+	webhooks := newWebhookNotifier(cfg)
+
+	// create the notification channel
+	notifyChan := make(chan Notify, cfg.WorkerQueueSize)
+
+	// start workers here
+	var workers sync.WaitGroup
+	for w := 1; w <= cfg.Workers; w++ {
+		workers.Add(1)
+		go func(workerId int) {
+			defer workers.Done()
+			worker(stopCtx, jobCtx, workerId, cfg, nil, nil, "", "", events, webhooks, notifyChan)
+		}(w)
+	}
+
+	// returns when we are asked to shut down
+	watchDirectory(stopCtx, cfg, notifyChan)
+
+	deadline := time.Now().Add(time.Duration(cfg.ShutdownGracePeriod) * time.Second)
+	close(notifyChan)
+	drainWorkers(cfg, &workers, cancelJobs)
+	webhooks.stop(deadline)
+	log.Printf("[main] ===> %s service shut down <===", os.Args[0])
 }
 
 //
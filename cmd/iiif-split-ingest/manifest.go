@@ -11,11 +11,12 @@ import (
 )
 
 type Image struct {
-	Id       string // file basename without extension
-	Filename string // file basename
-	Width    string // image width
-	Height   string // image height
-	Format   string // image format
+	Id         string                     // file basename without extension
+	Filename   string                     // file basename
+	Width      string                     // image width
+	Height     string                     // image height
+	Format     string                     // image format
+	Renditions map[string]RenditionOutput // additional renditions keyed by name (e.g. {{(index .Renditions "thumbnail").URL}})
 }
 
 type Metadata struct {
@@ -34,10 +35,10 @@ type ManifestData struct {
 	Pages     []Image // image details for each page
 }
 
-func createManifest(workerId int, config ServiceConfig, inputFile string, convertedFiles []string) error {
+func createManifest(workerId int, config ServiceConfig, inputFile string, convertedPages []PageResult) error {
 
 	// generate the manifest data
-	md, err := createManifestData(workerId, config, inputFile, convertedFiles)
+	md, err := createManifestData(workerId, config, inputFile, convertedPages)
 	if err != nil {
 		return err
 	}
@@ -60,10 +61,10 @@ func createManifest(workerId int, config ServiceConfig, inputFile string, conver
 	return nil
 }
 
-func createManifestData(workerId int, config ServiceConfig, inputFile string, convertedFiles []string) (*ManifestData, error) {
+func createManifestData(workerId int, config ServiceConfig, inputFile string, convertedPages []PageResult) (*ManifestData, error) {
 
 	// get attributes of all the pages (images) in the manifest
-	pages, err := createPageAttributes(workerId, config, convertedFiles)
+	pages, err := createPageAttributes(workerId, config, convertedPages)
 	if err != nil {
 		return nil, err
 	}
@@ -89,24 +90,24 @@ func createManifestData(workerId int, config ServiceConfig, inputFile string, co
 	return &manifestData, nil
 }
 
-func createPageAttributes(workerId int, config ServiceConfig, convertedFiles []string) ([]Image, error) {
+func createPageAttributes(workerId int, config ServiceConfig, convertedPages []PageResult) ([]Image, error) {
 
 	// create our helper
 	et, err := exiftool.NewExiftool()
 	if err != nil {
 		log.Printf("ERROR: initializing exiftool (%s)", err.Error())
-		return nil, err
+		return nil, fmt.Errorf("%w: starting exiftool (%s)", ErrInspector, err.Error())
 	}
 	defer et.Close()
 
 	// our list of page attributes
-	pages := make([]Image, len(convertedFiles))
+	pages := make([]Image, len(convertedPages))
 
 	// go through each page/file
-	for ix, fn := range convertedFiles {
+	for ix, cp := range convertedPages {
 
 		// extract the metadata
-		infos := et.ExtractMetadata(fn)
+		infos := et.ExtractMetadata(cp.TargetFile)
 		if infos[0].Err == nil {
 			ext, _ := infos[0].GetString("FileTypeExtension")
 			suffix := fmt.Sprintf(".%s", ext)
@@ -115,10 +116,11 @@ func createPageAttributes(workerId int, config ServiceConfig, convertedFiles []s
 			pages[ix].Height, _ = infos[0].GetString("ImageHeight")
 			pages[ix].Width, _ = infos[0].GetString("ImageWidth")
 			pages[ix].Format, _ = infos[0].GetString("MIMEType")
+			pages[ix].Renditions = cp.Renditions
 			//log.Printf("DEBUG: %s/%s (w %s, h %s, f %s)", pages[ix].Id, pages[ix].Filename, pages[ix].Width, pages[ix].Height, pages[ix].Format)
 		} else {
 			log.Printf("ERROR: extracting metadata (%s)", infos[0].Err)
-			return nil, infos[0].Err
+			return nil, exiftoolError(infos[0].Err)
 		}
 	}
 	return pages, nil
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SequencedSplitter - a splitter that already returns its pages in page order, they are not reordered
+type SequencedSplitter interface {
+	Splitter
+	Sequenced() bool
+}
+
+// put the split pages in page order unless the splitter has already done so
+func orderPages(workerId int, config ServiceConfig, splitter Splitter, pages []string) ([]string, error) {
+
+	if s, ok := splitter.(SequencedSplitter); ok == true && s.Sequenced() == true {
+		return pages, nil
+	}
+
+	ordered, err := sortPageNames(config, pages)
+	if err != nil {
+		return nil, err
+	}
+
+	for ix, p := range ordered {
+		log.Printf("[worker %d] DEBUG: page %d is '%s'", workerId, ix+1, path.Base(p))
+	}
+	return ordered, nil
+}
+
+// the sort key of a page file
+type pageSortKey struct {
+	name     string // the file name without the suffix
+	number   int64  // the page number (if matched)
+	numbered bool   // the page number was extracted from the name
+}
+
+// sort page file names by the page number extracted with the configured pattern (if any), falling back
+// to a natural sort of the file names. Names the pattern matches come before the ones it does not so
+// the comparison is consistent whatever mix of names we are given
+func sortPageNames(config ServiceConfig, pages []string) ([]string, error) {
+
+	var pattern *regexp.Regexp
+	if len(config.PageNumberPattern) != 0 {
+		var err error
+		pattern, err = compilePageNumberPattern(config.PageNumberPattern)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the keys are extracted once and sorted along with the names
+	ordered := make([]string, len(pages))
+	copy(ordered, pages)
+	keys := make([]pageSortKey, len(ordered))
+	for ix, p := range ordered {
+		keys[ix].name = strings.TrimSuffix(path.Base(p), path.Ext(p))
+		if pattern != nil {
+			keys[ix].number, keys[ix].numbered = extractPageNumber(pattern, keys[ix].name)
+		}
+	}
+
+	sort.Stable(pageSorter{pages: ordered, keys: keys})
+	return ordered, nil
+}
+
+// sorts the page names using their keys
+type pageSorter struct {
+	pages []string
+	keys  []pageSortKey
+}
+
+func (s pageSorter) Len() int { return len(s.pages) }
+
+func (s pageSorter) Swap(i, j int) {
+	s.pages[i], s.pages[j] = s.pages[j], s.pages[i]
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
+
+func (s pageSorter) Less(i, j int) bool {
+	a, b := s.keys[i], s.keys[j]
+	if a.numbered != b.numbered {
+		return a.numbered == true
+	}
+	if a.numbered == true && a.number != b.number {
+		return a.number < b.number
+	}
+	return naturalLess(a.name, b.name)
+}
+
+// compile the page number pattern, it must contain a capture group for the page number
+func compilePageNumberPattern(pattern string) (*regexp.Regexp, error) {
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if re.NumSubexp() < 1 {
+		return nil, fmt.Errorf("page number pattern [%s] has no capture group", pattern)
+	}
+	return re, nil
+}
+
+// extract the page number from a file name using the first capture group of the pattern
+func extractPageNumber(pattern *regexp.Regexp, name string) (int64, bool) {
+
+	match := pattern.FindStringSubmatch(name)
+	if len(match) < 2 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// compare two strings treating runs of digits as numbers, so "page-2" comes before "page-10"
+func naturalLess(a string, b string) bool {
+
+	for len(a) != 0 && len(b) != 0 {
+		ca, cb := a[0], b[0]
+		if isDigit(ca) == true && isDigit(cb) == true {
+			na, ra := leadingDigits(a)
+			nb, rb := leadingDigits(b)
+
+			// compare the numbers ignoring leading zeros
+			ta := strings.TrimLeft(na, "0")
+			tb := strings.TrimLeft(nb, "0")
+			if len(ta) != len(tb) {
+				return len(ta) < len(tb)
+			}
+			if ta != tb {
+				return ta < tb
+			}
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			a, b = ra, rb
+			continue
+		}
+		if ca != cb {
+			return ca < cb
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+// split a string into its leading digits and the remainder
+func leadingDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) == true {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+//
+// end of file
+//
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/uvalib/uva-aws-s3-sdk/uva-s3"
+)
+
+// PageResult - the outcome of converting and outputting a single page
+type PageResult struct {
+	TargetFile string                     // the file used when generating the manifest
+	Output     string                     // the output location
+	Renditions map[string]RenditionOutput // the additional renditions of the page (if any)
+	Fixity     *Fixity                    // the checksums of the output (if we are recording fixity)
+}
+
+// convert and output all the pages of a document, up to PageWorkers at a time. The results are returned in
+// page order. Processing stops at the first failure and the error for that page is returned
+func convertPages(ctx context.Context, workerId int, config ServiceConfig, s3Svc uva_s3.UvaS3, journal *Journal, downloadedName string, convertFiles []string) ([]PageResult, error) {
+
+	// used to abandon the remaining pages when one fails
+	pageCtx, cancelPages := context.WithCancel(ctx)
+	defer cancelPages()
+
+	results := make([]PageResult, len(convertFiles))
+	slots := make(chan struct{}, config.PageWorkers)
+	var firstErr error
+	var failed sync.Once
+	var pages sync.WaitGroup
+
+	for ix, inputName := range convertFiles {
+
+		// wait for a free slot unless we are giving up
+		select {
+		case slots <- struct{}{}:
+		case <-pageCtx.Done():
+		}
+		if pageCtx.Err() != nil {
+			break
+		}
+
+		// pages completed by an earlier attempt do not need to be done again
+		previous := journal.page(inputName)
+		if previous != nil {
+			log.Printf("[worker %d] DEBUG: page %d already complete", workerId, ix+1)
+			results[ix] = *previous
+			<-slots
+			continue
+		}
+
+		pages.Add(1)
+		go func(ix int, inputName string) {
+			defer pages.Done()
+			defer func() { <-slots }()
+
+			result, err := convertPage(pageCtx, workerId, config, s3Svc, downloadedName, ix+1, inputName)
+			if err != nil {
+				failed.Do(func() {
+					log.Printf("[worker %d] ERROR: page %d failed, abandoning remaining pages", workerId, ix+1)
+					firstErr = err
+					cancelPages()
+				})
+				return
+			}
+			results[ix] = *result
+			journal.recordPage(inputName, *result)
+		}(ix, inputName)
+	}
+
+	pages.Wait()
+
+	if firstErr != nil {
+		return results, firstErr
+	}
+	return results, ctx.Err()
+}
+
+// convert a single page and write it to the output location
+func convertPage(ctx context.Context, workerId int, config ServiceConfig, s3Svc uva_s3.UvaS3, downloadedName string, page int, inputName string) (*PageResult, error) {
+
+	// generate all the needed file names
+	convertedName, targetName := generateImageFilenames(workerId, config, downloadedName, inputName)
+
+	// convert the file
+	err := convertFile(ctx, workerId, config, downloadedName, page, inputName, convertedName)
+	if err != nil {
+		return nil, err
+	}
+
+	// stop if we have been cancelled
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	// if we are outputting to a local filesystem
+	if len(config.OutputFSRoot) != 0 {
+		// create the target directory tree
+		err = createDir(workerId, path.Dir(targetName))
+		if err != nil {
+			return nil, err
+		}
+
+		// copy the file to the correct location
+		err = copyFile(workerId, convertedName, targetName)
+		if err != nil {
+			_ = os.Remove(targetName)
+			return nil, err
+		}
+
+		// checksum what was actually written
+		fixity, err := outputFixity(workerId, config, targetName, targetName)
+		if err != nil {
+			return nil, err
+		}
+
+		// create any additional renditions
+		renditions, err := convertRenditions(ctx, workerId, config, s3Svc, downloadedName, page, inputName)
+		if err != nil {
+			return nil, err
+		}
+
+		// and save the output file in case we need to make a manifest
+		return &PageResult{TargetFile: targetName, Output: targetName, Renditions: renditions, Fixity: fixity}, nil
+	}
+
+	// do we have a bucket root defined
+	f := targetName
+	if len(config.OutputBucketRoot) != 0 {
+		f = fmt.Sprintf("%s/%s", config.OutputBucketRoot, f)
+	}
+	fixity, err := outputFixity(workerId, config, convertedName, f)
+	if err != nil {
+		return nil, err
+	}
+	err = putOutputFile(workerId, s3Svc, config.OutputBucket, f, convertedName, fixity)
+	if err != nil {
+		return nil, err
+	}
+
+	// create any additional renditions
+	renditions, err := convertRenditions(ctx, workerId, config, s3Svc, downloadedName, page, inputName)
+	if err != nil {
+		return nil, err
+	}
+
+	// and save the output file in case we need to make a manifest
+	return &PageResult{TargetFile: convertedName, Output: fmt.Sprintf("s3://%s/%s", config.OutputBucket, f), Renditions: renditions, Fixity: fixity}, nil
+}
+
+//
+// end of file
+//
@@ -1,15 +1,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"os/exec"
 	"path"
 	"strings"
 	"time"
 )
 
-func splitFile(workerId int, config ServiceConfig, inputName string) ([]string, error) {
+func splitFile(ctx context.Context, workerId int, config ServiceConfig, inputName string) ([]string, error) {
 
 	// split into interesting components
 	dirName := path.Dir(inputName)
@@ -20,20 +20,28 @@ func splitFile(workerId int, config ServiceConfig, inputName string) ([]string,
 	// specify the output template
 	outputTemplate := fmt.Sprintf("%s/%s", dirName, baseNoExt)
 
-	// build the command line
-	cmdLine := strings.Replace(config.SplitCommandLine, config.SplitCommandInFileToken, inputName, 1)
-	cmdLine = strings.Replace(cmdLine, config.SplitCommandOutFileToken, outputTemplate, 1)
-
 	// build the parameter structure
-	params := strings.Split(cmdLine, " ")
-	cmd := exec.Command(config.SplitBinary, params...)
+	params, err := buildCommandArgs(config.SplitCommandLine, map[string]string{
+		"in":      inputName,
+		"out":     outputTemplate,
+		"id":      idFromFilename(inputName),
+		"workdir": dirName,
+		"dpi":     fmt.Sprintf("%d", config.PdfSplitDpi),
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	log.Printf("[worker %d] DEBUG: split command \"%s\"", workerId, cmd.String())
+	log.Printf("[worker %d] DEBUG: split command \"%s %s\"", workerId, config.SplitBinary, strings.Join(params, " "))
 
 	start := time.Now()
-	output, err := cmd.CombinedOutput()
+	output, err := runCommand(ctx, time.Duration(config.SplitTimeout)*time.Second, config.SplitBinary, params)
 	if err != nil {
-		log.Printf("[worker %d] ERROR: splitting %s (%s)", workerId, inputName, err.Error())
+		if isTimeout(err) == true {
+			log.Printf("[worker %d] ERROR: TIMEOUT splitting %s (%s)", workerId, inputName, err.Error())
+		} else {
+			log.Printf("[worker %d] ERROR: splitting %s (%s)", workerId, inputName, err.Error())
+		}
 		if len(output) != 0 {
 			log.Printf("[worker %d] ERROR: split output [%s]", workerId, output)
 		}
@@ -60,21 +68,42 @@ func splitFile(workerId int, config ServiceConfig, inputName string) ([]string,
 	return outputFiles, err
 }
 
-func convertFile(workerId int, config ServiceConfig, inputFile string, outputFile string) error {
+func convertFile(ctx context.Context, workerId int, config ServiceConfig, downloadedName string, page int, inputFile string, outputFile string) error {
 
-	// build the command line
-	cmdLine := strings.Replace(config.ConvertCommandLine, config.SplitCommandInFileToken, inputFile, 1)
-	cmdLine = strings.Replace(cmdLine, config.SplitCommandOutFileToken, outputFile, 1)
+	err := renderFile(ctx, workerId, config, config.ConvertBinary, config.ConvertCommandLine, downloadedName, page, inputFile, outputFile)
+	if err != nil {
+		return err
+	}
+
+	// a zero exit status does not guarantee a usable image
+	return validateImage(ctx, workerId, config, page, outputFile, true)
+}
+
+// convert a page using the specified binary and commandline template
+func renderFile(ctx context.Context, workerId int, config ServiceConfig, binary string, commandLine string, downloadedName string, page int, inputFile string, outputFile string) error {
 
 	// build the parameter structure
-	params := strings.Split(cmdLine, " ")
-	cmd := exec.Command(config.ConvertBinary, params...)
+	params, err := buildCommandArgs(commandLine, map[string]string{
+		"in":      inputFile,
+		"out":     outputFile,
+		"id":      idFromFilename(downloadedName),
+		"page":    fmt.Sprintf("%d", page),
+		"workdir": path.Dir(inputFile),
+		"dpi":     fmt.Sprintf("%d", config.PdfSplitDpi),
+	})
+	if err != nil {
+		return err
+	}
 
-	log.Printf("[worker %d] DEBUG: convert command \"%s\"", workerId, cmd.String())
+	log.Printf("[worker %d] DEBUG: convert command \"%s %s\"", workerId, binary, strings.Join(params, " "))
 	start := time.Now()
-	output, err := cmd.CombinedOutput()
+	output, err := runCommand(ctx, time.Duration(config.ConvertPageTimeout)*time.Second, binary, params)
 	if err != nil {
-		log.Printf("[worker %d] ERROR: converting %s (%s)", workerId, inputFile, err.Error())
+		if isTimeout(err) == true {
+			log.Printf("[worker %d] ERROR: TIMEOUT converting %s (%s)", workerId, inputFile, err.Error())
+		} else {
+			log.Printf("[worker %d] ERROR: converting %s (%s)", workerId, inputFile, err.Error())
+		}
 		if len(output) != 0 {
 			log.Printf("[worker %d] ERROR: conversion output [%s]", workerId, output)
 		}
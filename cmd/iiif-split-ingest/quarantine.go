@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/uvalib/virgo4-sqs-sdk/awssqs"
+)
+
+// the attribute names used to annotate quarantined messages
+var attributeKeyQuarantineReason = "quarantine-reason"
+var attributeKeyQuarantineTime = "quarantine-time"
+
+// QuarantineRecord - the structure written to the quarantine directory for each poison message
+type QuarantineRecord struct {
+	Reason     string            `json:"reason"`      // why the message was quarantined
+	Quarantine string            `json:"quarantined"` // when the message was quarantined
+	FirstSent  uint64            `json:"first_sent"`  // when the message was first sent (epoch milliseconds)
+	Attributes map[string]string `json:"attributes"`  // the message attributes
+	Payload    string            `json:"payload"`     // the original message payload
+}
+
+// move a message that cannot be processed to the dead letter queue or the quarantine directory (whichever
+// is configured) and remove it from the inbound queue so it does not get redelivered
+func quarantineMessage(config ServiceConfig, aws awssqs.AWS_SQS, inQueueHandle awssqs.QueueHandle, deadQueueHandle awssqs.QueueHandle, message awssqs.Message, reason string) error {
+
+	log.Printf("[main] ERROR: quarantining inbound message (%s)", reason)
+
+	// if we could not quarantine the message, leave it on the queue so we do not lose it
+	quarantined, err := storeQuarantined(config, aws, deadQueueHandle, message, reason)
+	if err != nil || quarantined == false {
+		return err
+	}
+
+	// and remove it from the inbound queue
+	return deleteInboundMessage(aws, inQueueHandle, message.ReceiptHandle)
+}
+
+// quarantine a single object that failed to process. The quarantined payload is a synthesized S3 event for
+// just this object so it can be redriven to the inbound queue once the problem is fixed. Returns true if
+// the object was quarantined
+func quarantineObject(workerId int, config ServiceConfig, aws awssqs.AWS_SQS, deadQueueHandle awssqs.QueueHandle, notify Notify, reason string) (bool, error) {
+
+	log.Printf("[worker %d] ERROR: quarantining %s/%s (%s)", workerId, notify.SourceBucket, notify.BucketKey, reason)
+
+	record := S3EventRecord{}
+	record.S3.Bucket.Name = notify.SourceBucket
+	record.S3.Object.Key = url.QueryEscape(notify.BucketKey)
+	record.S3.Object.Size = notify.ExpectedSize
+	record.S3.Object.ETag = notify.ETag
+
+	event := struct {
+		Records []S3EventRecord `json:"Records"`
+	}{Records: []S3EventRecord{record}}
+
+	b, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("[worker %d] ERROR: json marshal (%s)", workerId, err.Error())
+		return false, err
+	}
+
+	message := awssqs.Message{Payload: b, FirstSent: uint64(time.Now().UnixMilli())}
+	return storeQuarantined(config, aws, deadQueueHandle, message, reason)
+}
+
+// send the message to the dead letter queue or write it to the quarantine directory (whichever is
+// configured), returns false if neither is configured
+func storeQuarantined(config ServiceConfig, aws awssqs.AWS_SQS, deadQueueHandle awssqs.QueueHandle, message awssqs.Message, reason string) (bool, error) {
+
+	switch {
+	case len(deadQueueHandle) != 0:
+		return true, sendToDeadLetterQueue(aws, deadQueueHandle, message, reason)
+	case len(config.QuarantineDir) != 0:
+		return true, writeToQuarantineDir(config.QuarantineDir, message, reason)
+	}
+
+	// nowhere to put it, leave it on the queue and let the queue redrive policy (if any) deal with it
+	log.Printf("[main] WARNING: no dead letter queue or quarantine directory configured, leaving message on the queue")
+	log.Printf("[main] WARNING: message payload [%s]", string(message.Payload))
+	return false, nil
+}
+
+// send a copy of the message to the dead letter queue annotated with the reason
+func sendToDeadLetterQueue(aws awssqs.AWS_SQS, deadQueueHandle awssqs.QueueHandle, message awssqs.Message, reason string) error {
+
+	attribs := make(awssqs.Attributes, 0, len(message.Attribs)+2)
+	attribs = append(attribs, message.Attribs...)
+	attribs = append(attribs, awssqs.Attribute{Name: attributeKeyQuarantineReason, Value: reason})
+	attribs = append(attribs, awssqs.Attribute{Name: attributeKeyQuarantineTime, Value: time.Now().UTC().Format(time.RFC3339)})
+
+	messages := []awssqs.Message{{Attribs: attribs, Payload: message.Payload}}
+	opStatus, err := aws.BatchMessagePut(deadQueueHandle, messages)
+	if err != nil {
+		if err != awssqs.ErrOneOrMoreOperationsUnsuccessful {
+			log.Printf("[main] ERROR: failed to send message to the dead letter queue (%s)", err.Error())
+			return err
+		}
+
+		// retry the failed put
+		err = aws.MessagePutRetry(deadQueueHandle, messages, opStatus, 3)
+		if err != nil {
+			log.Printf("[main] ERROR: failed to send message to the dead letter queue (%s)", err.Error())
+			return err
+		}
+	}
+
+	log.Printf("[main] INFO: message sent to the dead letter queue")
+	return nil
+}
+
+// write the message and the reason to a file in the quarantine directory
+func writeToQuarantineDir(quarantineDir string, message awssqs.Message, reason string) error {
+
+	now := time.Now().UTC()
+	record := QuarantineRecord{
+		Reason:     reason,
+		Quarantine: now.Format(time.RFC3339),
+		FirstSent:  message.FirstSent,
+		Attributes: make(map[string]string),
+		Payload:    string(message.Payload),
+	}
+	for _, a := range message.Attribs {
+		record.Attributes[a.Name] = a.Value
+	}
+
+	b, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		log.Printf("[main] ERROR: json marshal (%s)", err.Error())
+		return err
+	}
+
+	filename := fmt.Sprintf("%s/message-%s.json", quarantineDir, now.Format("20060102150405.000000000"))
+	err = writeFile(filename, string(b))
+	if err != nil {
+		log.Printf("[main] ERROR: writing %s (%s)", filename, err.Error())
+		return err
+	}
+
+	log.Printf("[main] INFO: message quarantined to %s", filename)
+	return nil
+}
+
+//
+// end of file
+//
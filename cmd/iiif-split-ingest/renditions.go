@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/uvalib/uva-aws-s3-sdk/uva-s3"
+)
+
+// Rendition - an additional derivative created from every page (e.g. a thumbnail)
+type Rendition struct {
+	Name             string // the rendition name
+	Binary           string // the conversion binary
+	CommandLine      string // the conversion commandline template
+	Suffix           string // the suffix of converted files
+	OutputFSRoot     string // the output directory (when writing to the filesystem)
+	OutputBucketRoot string // the output bucket root (when writing to the output bucket)
+	URLRoot          string // the root URL the outputs are available from (optional)
+}
+
+// RenditionOutput - a single rendition of a page
+type RenditionOutput struct {
+	Filename string  `json:"filename"`         // the rendition file basename
+	Output   string  `json:"output"`           // the output location
+	URL      string  `json:"url"`              // the URL if a URL root is configured, otherwise the output location
+	Fixity   *Fixity `json:"fixity,omitempty"` // the checksums of the output (if we are recording fixity)
+}
+
+// rendition names become part of environment variable names
+var renditionNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// load the configuration of each named rendition, any issues are fatal
+func loadRenditions(cfg *ServiceConfig) []Rendition {
+
+	renditions := make([]Rendition, 0)
+	for _, name := range strings.Split(cfg.RenditionNames, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		if renditionNamePattern.MatchString(name) == false {
+			log.Printf("[main] ERROR: rendition name [%s] is invalid (lower case letters, digits and underscores only)", name)
+			os.Exit(1)
+		}
+
+		prefix := fmt.Sprintf("IIIF_INGEST_RENDITION_%s", strings.ToUpper(name))
+		r := Rendition{Name: name}
+		r.Binary = envWithDefault(fmt.Sprintf("%s_BIN", prefix), cfg.ConvertBinary)
+		r.CommandLine = ensureSetAndNonEmpty(fmt.Sprintf("%s_CMD", prefix))
+		r.Suffix = ensureSetAndNonEmpty(fmt.Sprintf("%s_SUFFIX", prefix))
+		if len(cfg.OutputFSRoot) != 0 {
+			r.OutputFSRoot = envWithDefault(fmt.Sprintf("%s_OUTPUT_FS_ROOT", prefix), fmt.Sprintf("%s/%s", cfg.OutputFSRoot, name))
+		}
+		r.OutputBucketRoot = envWithDefault(fmt.Sprintf("%s_OUTPUT_BUCKET_ROOT", prefix), path.Join(cfg.OutputBucketRoot, name))
+		r.URLRoot = strings.TrimSuffix(envWithDefault(fmt.Sprintf("%s_URL_ROOT", prefix), ""), "/")
+
+		err := validateCommandTemplate(r.CommandLine, convertTemplateVariables)
+		if err != nil {
+			log.Printf("[main] ERROR: %s rendition command is invalid (%s)", name, err.Error())
+			os.Exit(1)
+		}
+
+		log.Printf("[CONFIG] Rendition %s: bin [%s], cmd [%s], suffix [%s], fs root [%s], bucket root [%s], url root [%s]",
+			name, r.Binary, r.CommandLine, r.Suffix, r.OutputFSRoot, r.OutputBucketRoot, r.URLRoot)
+		renditions = append(renditions, r)
+	}
+
+	return renditions
+}
+
+// the rendition names in configuration order
+func renditionNames(config ServiceConfig) []string {
+	names := make([]string, 0, len(config.Renditions))
+	for _, r := range config.Renditions {
+		names = append(names, r.Name)
+	}
+	return names
+}
+
+// create each of the configured renditions of a page and write them to their output locations
+func convertRenditions(ctx context.Context, workerId int, config ServiceConfig, s3Svc uva_s3.UvaS3, downloadedName string, page int, inputName string) (map[string]RenditionOutput, error) {
+
+	if len(config.Renditions) == 0 {
+		return nil, nil
+	}
+
+	// split into interesting components
+	inputDirName := path.Dir(inputName)
+	inputBaseName := path.Base(inputName)
+	inputBaseNoExt := strings.TrimSuffix(inputBaseName, path.Ext(inputBaseName))
+	downloadBaseNoExt := idFromFilename(downloadedName)
+
+	outputs := make(map[string]RenditionOutput)
+	for _, r := range config.Renditions {
+
+		// stop if we have been cancelled
+		if ctx.Err() != nil {
+			return outputs, ctx.Err()
+		}
+
+		filename := fmt.Sprintf("%s.%s", inputBaseNoExt, r.Suffix)
+		convertedName := fmt.Sprintf("%s/%s.%s.%s", inputDirName, inputBaseNoExt, r.Name, r.Suffix)
+		relativeName := fmt.Sprintf("%s/%s", outputDirName(workerId, config, downloadBaseNoExt), filename)
+
+		err := renderFile(ctx, workerId, config, r.Binary, r.CommandLine, downloadedName, page, inputName, convertedName)
+		if err != nil {
+			return outputs, err
+		}
+
+		// renditions are expected to be a different size so only the common checks apply
+		err = validateImage(ctx, workerId, config, page, convertedName, false)
+		if err != nil {
+			return outputs, err
+		}
+
+		output := RenditionOutput{Filename: filename}
+		if len(config.OutputFSRoot) != 0 {
+			targetName := fmt.Sprintf("%s/%s", r.OutputFSRoot, relativeName)
+			err = createDir(workerId, path.Dir(targetName))
+			if err != nil {
+				return outputs, err
+			}
+			err = copyFile(workerId, convertedName, targetName)
+			if err != nil {
+				_ = os.Remove(targetName)
+				return outputs, err
+			}
+			output.Fixity, err = outputFixity(workerId, config, targetName, targetName)
+			if err != nil {
+				return outputs, err
+			}
+			output.Output = targetName
+		} else {
+			key := relativeName
+			if len(r.OutputBucketRoot) != 0 {
+				key = fmt.Sprintf("%s/%s", r.OutputBucketRoot, relativeName)
+			}
+			output.Fixity, err = outputFixity(workerId, config, convertedName, key)
+			if err != nil {
+				return outputs, err
+			}
+			err = putOutputFile(workerId, s3Svc, config.OutputBucket, key, convertedName, output.Fixity)
+			if err != nil {
+				return outputs, err
+			}
+			output.Output = fmt.Sprintf("s3://%s/%s", config.OutputBucket, key)
+		}
+
+		output.URL = output.Output
+		if len(r.URLRoot) != 0 {
+			output.URL = fmt.Sprintf("%s/%s", r.URLRoot, relativeName)
+		}
+		outputs[r.Name] = output
+	}
+
+	return outputs, nil
+}
+
+//
+// end of file
+//
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/uvalib/uva-aws-s3-sdk/uva-s3"
+)
+
+// the error classes
+var errorClassTransient = "transient"
+var errorClassPermanent = "permanent"
+
+// ErrRetryAbandoned - we were asked to shut down while waiting to retry a job
+var ErrRetryAbandoned = fmt.Errorf("retry abandoned during shutdown")
+
+// JobFailure - the final outcome of a job that could not be completed
+type JobFailure struct {
+	Class    string // the error class (transient or permanent)
+	Attempts int    // the number of attempts made
+	Err      error  // the error from the last attempt
+}
+
+func (f *JobFailure) Error() string {
+	return fmt.Sprintf("%s error after %d attempt(s): %s", f.Class, f.Attempts, f.Err.Error())
+}
+
+func (f *JobFailure) Unwrap() error {
+	return f.Err
+}
+
+// process a single inbound file, retrying transient failures with an exponential backoff. Any error
+// returned is a JobFailure unless we were asked to stop (ErrRetryAbandoned), another job is already processing
+// the same object (ErrJobInProgress) or the job was cancelled
+func processWithRetries(stopCtx context.Context, jobCtx context.Context, workerId int, config ServiceConfig, s3Svc uva_s3.UvaS3, notify Notify) (*JobSummary, error) {
+
+	backoff := time.Duration(config.RetryBackoff) * time.Second
+	maxBackoff := time.Duration(config.RetryBackoffMax) * time.Second
+
+	attempt := 1
+	for {
+		summary, err := processFile(jobCtx, workerId, config, s3Svc, notify)
+		summary.Attempts = attempt
+		if err == nil || jobCtx.Err() != nil || errors.Is(err, ErrJobInProgress) == true {
+			return summary, err
+		}
+
+		class := classifyError(err)
+		if class == errorClassPermanent || attempt >= config.MaxAttempts {
+			failure := &JobFailure{Class: class, Attempts: attempt, Err: err}
+			log.Printf("[worker %d] ERROR: processing %s failed (%s)", workerId, notify.BucketKey, failure.Error())
+			return summary, failure
+		}
+
+		log.Printf("[worker %d] WARNING: attempt %d of %d for %s failed with a transient error, retrying in %s (%s)",
+			workerId, attempt, config.MaxAttempts, notify.BucketKey, backoff, err.Error())
+
+		if sleepUnlessStopped(stopCtx, backoff) == false {
+			return summary, fmt.Errorf("%w (%s)", ErrRetryAbandoned, err.Error())
+		}
+
+		attempt++
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// classify an error as transient (worth retrying) or permanent
+func classifyError(err error) string {
+
+	if isTransient(err) == true {
+		return errorClassTransient
+	}
+	return errorClassPermanent
+}
+
+// is this an error that may go away if we try again
+func isTransient(err error) bool {
+
+	// our own transient conditions
+	if errors.Is(err, ErrCommandTimeout) == true ||
+		errors.Is(err, ErrInsufficientDiskSpace) == true ||
+		errors.Is(err, ErrIntegrity) == true ||
+		errors.Is(err, ErrInspector) == true ||
+		errors.Is(err, context.DeadlineExceeded) == true {
+		return true
+	}
+
+	// we ran out of a local resource, it may be released by other jobs
+	if errors.Is(err, syscall.ENOSPC) == true ||
+		errors.Is(err, syscall.EMFILE) == true ||
+		errors.Is(err, syscall.ENFILE) == true ||
+		errors.Is(err, syscall.ENOMEM) == true ||
+		errors.Is(err, syscall.EAGAIN) == true {
+		return true
+	}
+
+	// AWS throttling, service (5xx) and connection errors
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) == true {
+		if request.IsErrorRetryable(awsErr) == true || request.IsErrorThrottle(awsErr) == true {
+			return true
+		}
+	}
+
+	// a remote service (the metadata service for example) is overloaded or having problems
+	var statusErr *HttpStatusError
+	if errors.As(err, &statusErr) == true && canRetryStatus(statusErr.Status) == true {
+		return true
+	}
+
+	// network errors
+	var netErr net.Error
+	if errors.As(err, &netErr) == true {
+		return true
+	}
+
+	return canRetry(err)
+}
+
+//
+// end of file
+//
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// the AWS session and the S3 clients we use directly (the uva_s3 helper does not do everything we need),
+// they are created once and shared by the workers
+var awsClients struct {
+	sync.Once
+	sess     *session.Session
+	s3       *s3.S3
+	uploader *s3manager.Uploader
+	err      error
+}
+
+// create the shared AWS session and clients (if necessary)
+func initAwsClients() error {
+
+	awsClients.Do(func() {
+		sess, err := session.NewSession()
+		if err != nil {
+			log.Printf("[main] ERROR: creating AWS session (%s)", err.Error())
+			awsClients.err = err
+			return
+		}
+		awsClients.sess = sess
+		awsClients.s3 = s3.New(sess)
+		awsClients.uploader = s3manager.NewUploader(sess)
+	})
+	return awsClients.err
+}
+
+// get the shared AWS session
+func awsSession() (*session.Session, error) {
+	err := initAwsClients()
+	return awsClients.sess, err
+}
+
+// get the shared S3 client
+func s3Client() (*s3.S3, error) {
+	err := initAwsClients()
+	return awsClients.s3, err
+}
+
+// get the shared S3 uploader, used when the object metadata must be set
+func s3Uploader() (*s3manager.Uploader, error) {
+	err := initAwsClients()
+	return awsClients.uploader, err
+}
+
+// BucketObject - an object located when listing a bucket
+type BucketObject struct {
+	Key          string    // the object key
+	Size         int64     // the object size
+	ETag         string    // the object ETag
+	LastModified time.Time // when the object was last modified
+}
+
+// list all the objects in a bucket under the specified prefix (ignoring 'directory' placeholders)
+func listBucketObjects(bucket string, prefix string) ([]BucketObject, error) {
+
+	svc, err := s3Client()
+	if err != nil {
+		return nil, err
+	}
+
+	objects := make([]BucketObject, 0)
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}
+	err = svc.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, o := range page.Contents {
+			key := aws.StringValue(o.Key)
+			if strings.HasSuffix(key, "/") == true {
+				continue
+			}
+			objects = append(objects, BucketObject{
+				Key:          key,
+				Size:         aws.Int64Value(o.Size),
+				ETag:         normalizeETag(aws.StringValue(o.ETag)),
+				LastModified: aws.TimeValue(o.LastModified),
+			})
+		}
+		return true
+	})
+
+	if err != nil {
+		log.Printf("[main] ERROR: listing %s/%s (%s)", bucket, prefix, err.Error())
+		return nil, err
+	}
+
+	log.Printf("[main] INFO: located %d object(s) in %s/%s", len(objects), bucket, prefix)
+	return objects, nil
+}
+
+// get the ETag of an S3 object, returns an empty string if it cannot be determined
+func getS3ETag(workerId int, bucket string, key string) string {
+
+	head, err := headS3Object(bucket, key)
+	if err != nil {
+		log.Printf("[worker %d] WARNING: getting attributes of %s/%s (%s)", workerId, bucket, key, err.Error())
+		return ""
+	}
+
+	return normalizeETag(aws.StringValue(head.ETag))
+}
+
+// get the attributes of an S3 object
+func headS3Object(bucket string, key string) (*s3.HeadObjectOutput, error) {
+
+	svc, err := s3Client()
+	if err != nil {
+		return nil, err
+	}
+
+	return svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+}
+
+// get the contents of an S3 object, the caller must close the body
+func getS3Object(bucket string, key string) (*s3.GetObjectOutput, error) {
+
+	svc, err := s3Client()
+	if err != nil {
+		return nil, err
+	}
+
+	return svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+}
+
+// S3 returns the ETag in quotes, remove them
+func normalizeETag(etag string) string {
+	return strings.Trim(etag, "\"")
+}
+
+//
+// end of file
+//
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// create a context that is cancelled when we receive a termination signal
+func newShutdownContext() (context.Context, context.CancelFunc) {
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		log.Printf("[main] INFO: shutdown requested, no longer accepting new work")
+	}()
+	return ctx, cancel
+}
+
+// sleep for the specified duration, returns false if we are asked to stop while sleeping
+func sleepUnlessStopped(ctx context.Context, duration time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(duration):
+		return true
+	}
+}
+
+// wait for the workers to finish their in-flight jobs, cancelling them if they do not
+// finish within the grace period
+func drainWorkers(config ServiceConfig, workers *sync.WaitGroup, cancelJobs context.CancelFunc) {
+
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+
+	log.Printf("[main] INFO: waiting up to %d seconds for in-flight jobs to complete", config.ShutdownGracePeriod)
+	select {
+	case <-done:
+		log.Printf("[main] INFO: all in-flight jobs complete")
+		return
+	case <-time.After(time.Duration(config.ShutdownGracePeriod) * time.Second):
+		log.Printf("[main] WARNING: grace period expired, cancelling in-flight jobs")
+		cancelJobs()
+	}
+
+	// the workers clean up after themselves once cancelled
+	<-done
+	log.Printf("[main] INFO: all in-flight jobs cancelled")
+}
+
+// remove output files written by a job that was cancelled so we do not leave a partial output tree behind
+func removeOutputFiles(workerId int, files []string) {
+
+	for _, f := range files {
+		log.Printf("[worker %d] INFO: removing partial output %s", workerId, f)
+		err := os.Remove(f)
+		if err != nil && os.IsNotExist(err) == false {
+			log.Printf("[worker %d] WARNING: failed to remove %s (%s)", workerId, f, err.Error())
+		}
+	}
+
+	// and the containing directories if they are now empty (ignore errors, they may not be)
+	removed := make(map[string]bool)
+	for _, f := range files {
+		dir := path.Dir(f)
+		if removed[dir] == false {
+			_ = os.Remove(dir)
+			removed[dir] = true
+		}
+	}
+}
+
+//
+// end of file
+//
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Splitter - splits an inbound document into the individual page files to be converted
+type Splitter interface {
+	// the name used to select the splitter in the configuration
+	Name() string
+
+	// split the input file into pages (in the same directory), returns the page files in page order
+	Split(ctx context.Context, workerId int, config ServiceConfig, inputName string) ([]string, error)
+}
+
+// SplitterRule - selects a splitter for files of the specified MIME type
+type SplitterRule struct {
+	MimeType string // the MIME type, a type wildcard (image/*) or * to match everything
+	Splitter string // the splitter name
+}
+
+// the available splitters
+var splitters = map[string]Splitter{
+	"none":    noneSplitter{},
+	"command": commandSplitter{},
+	"tiff":    tiffSplitter{},
+	"pdf":     pdfSplitter{},
+	"archive": archiveSplitter{},
+}
+
+// the MIME type we use if we cannot determine anything better
+var defaultMimeType = "application/octet-stream"
+
+// parse the splitter configuration, a comma separated list of mime=splitter rules evaluated in order
+func parseSplitterRules(spec string) ([]SplitterRule, error) {
+
+	rules := make([]SplitterRule, 0)
+	for _, r := range strings.Split(spec, ",") {
+		r = strings.TrimSpace(r)
+		if len(r) == 0 {
+			continue
+		}
+
+		mimeType, name, found := strings.Cut(r, "=")
+		mimeType = strings.ToLower(strings.TrimSpace(mimeType))
+		name = strings.TrimSpace(name)
+		if found == false || len(mimeType) == 0 || len(name) == 0 {
+			return nil, fmt.Errorf("invalid splitter rule [%s]", r)
+		}
+		if _, ok := splitters[name]; ok == false {
+			return nil, fmt.Errorf("unknown splitter [%s]", name)
+		}
+		rules = append(rules, SplitterRule{MimeType: mimeType, Splitter: name})
+	}
+
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("no splitter rules")
+	}
+	return rules, nil
+}
+
+// select the splitter to use for the specified file based on its MIME type
+func selectSplitter(workerId int, config ServiceConfig, inputName string) (Splitter, error) {
+
+	rules, err := parseSplitterRules(config.Splitters)
+	if err != nil {
+		return nil, err
+	}
+
+	mimeType, err := sniffMimeType(inputName)
+	if err != nil {
+		log.Printf("[worker %d] ERROR: determining the type of %s (%s)", workerId, inputName, err.Error())
+		return nil, err
+	}
+
+	for _, r := range rules {
+		if mimeTypeMatches(r.MimeType, mimeType) == true {
+			log.Printf("[worker %d] DEBUG: %s is %s, using the %s splitter", workerId, inputName, mimeType, r.Splitter)
+			return splitters[r.Splitter], nil
+		}
+	}
+
+	return nil, fmt.Errorf("no splitter configured for %s (%s)", inputName, mimeType)
+}
+
+// does the MIME type match the rule pattern
+func mimeTypeMatches(pattern string, mimeType string) bool {
+
+	if pattern == "*" || pattern == mimeType {
+		return true
+	}
+
+	// a type wildcard (e.g. image/*)
+	if strings.HasSuffix(pattern, "/*") == true {
+		return strings.HasPrefix(mimeType, strings.TrimSuffix(pattern, "*"))
+	}
+
+	return false
+}
+
+// determine the MIME type of a file from its content
+func sniffMimeType(filename string) (string, error) {
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	buf := make([]byte, 512)
+	n, err := f.Read(buf)
+	if err != nil && n == 0 {
+		return defaultMimeType, nil
+	}
+	buf = buf[:n]
+
+	// the standard library does not recognize TIFF or TAR
+	if isTiffHeader(buf) == true {
+		return "image/tiff", nil
+	}
+	if len(buf) >= 262 && string(buf[257:262]) == "ustar" {
+		return mimeTypeTar, nil
+	}
+
+	mimeType, _, _ := strings.Cut(http.DetectContentType(buf), ";")
+	return strings.TrimSpace(mimeType), nil
+}
+
+// noneSplitter - the file is a single page
+type noneSplitter struct{}
+
+func (noneSplitter) Name() string {
+	return "none"
+}
+
+func (noneSplitter) Split(ctx context.Context, workerId int, config ServiceConfig, inputName string) ([]string, error) {
+	return []string{inputName}, nil
+}
+
+// commandSplitter - splits the file using the configured external command
+type commandSplitter struct{}
+
+func (commandSplitter) Name() string {
+	return "command"
+}
+
+func (commandSplitter) Split(ctx context.Context, workerId int, config ServiceConfig, inputName string) ([]string, error) {
+	return splitFile(ctx, workerId, config, inputName)
+}
+
+//
+// end of file
+//
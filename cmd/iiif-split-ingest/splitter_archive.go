@@ -0,0 +1,357 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// archiveSplitter - unpacks a ZIP or TAR (optionally gzipped) archive of page images. The pages are
+// ordered by an included sequence file if there is one, otherwise by name, and renamed so they sort
+// in page order
+type archiveSplitter struct{}
+
+// the MIME types of the archives we understand
+var mimeTypeZip = "application/zip"
+var mimeTypeTar = "application/x-tar"
+var mimeTypeGzip = "application/x-gzip"
+
+// ArchiveLimits - protection against hostile or broken archives
+type ArchiveLimits struct {
+	MaxEntries int   // the maximum number of entries
+	MaxSize    int64 // the maximum total uncompressed size (in bytes)
+}
+
+// ArchiveEntry - a page image unpacked from an archive
+type ArchiveEntry struct {
+	Name     string // the name in the archive
+	Filename string // the unpacked file
+}
+
+func (archiveSplitter) Name() string {
+	return "archive"
+}
+
+// the pages are renamed in page order
+func (archiveSplitter) Sequenced() bool {
+	return true
+}
+
+func (archiveSplitter) Split(ctx context.Context, workerId int, config ServiceConfig, inputName string) ([]string, error) {
+
+	start := time.Now()
+
+	// split into interesting components
+	dirName := path.Dir(inputName)
+	baseNoExt := idFromFilename(inputName)
+
+	// unpack into a private directory, entries are written using generated names so nothing in the
+	// archive can influence where a file is written
+	unpackDir := fmt.Sprintf("%s/%s.unpack", dirName, baseNoExt)
+	err := createDir(workerId, unpackDir)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(unpackDir)
+
+	mimeType, err := sniffMimeType(inputName)
+	if err != nil {
+		return nil, err
+	}
+
+	limits := ArchiveLimits{MaxEntries: config.ArchiveMaxEntries, MaxSize: int64(config.ArchiveMaxSize) * 1024 * 1024}
+	var entries []ArchiveEntry
+	switch mimeType {
+	case mimeTypeZip:
+		entries, err = unpackZip(ctx, inputName, unpackDir, limits)
+	case mimeTypeTar, mimeTypeGzip:
+		entries, err = unpackTar(ctx, inputName, mimeType == mimeTypeGzip, unpackDir, limits)
+	default:
+		err = fmt.Errorf("unsupported archive type %s", mimeType)
+	}
+	if err != nil {
+		log.Printf("[worker %d] ERROR: unpacking %s (%s)", workerId, inputName, err.Error())
+		return nil, err
+	}
+
+	// determine the page order
+	pages, err := archivePageOrder(workerId, config, entries)
+	if err != nil {
+		log.Printf("[worker %d] ERROR: ordering pages of %s (%s)", workerId, inputName, err.Error())
+		return nil, err
+	}
+
+	// and move the pages out of the unpack directory using names that sort in page order
+	outputFiles := make([]string, 0, len(pages))
+	for ix, p := range pages {
+		pageName := fmt.Sprintf("%s/%s-%04d%s", dirName, baseNoExt, ix+1, strings.ToLower(path.Ext(p.Name)))
+		log.Printf("[worker %d] DEBUG: page %d is '%s'", workerId, ix+1, p.Name)
+		err = os.Rename(p.Filename, pageName)
+		if err != nil {
+			return nil, err
+		}
+		outputFiles = append(outputFiles, pageName)
+	}
+
+	duration := time.Since(start)
+	log.Printf("[worker %d] INFO: unpacked %d pages in %0.2f seconds", workerId, len(outputFiles), duration.Seconds())
+	return outputFiles, nil
+}
+
+// unpack the interesting entries of a ZIP archive
+func unpackZip(ctx context.Context, inputName string, unpackDir string, limits ArchiveLimits) ([]ArchiveEntry, error) {
+
+	r, err := zip.OpenReader(inputName)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	if len(r.File) > limits.MaxEntries {
+		return nil, fmt.Errorf("archive has %d entries, the limit is %d", len(r.File), limits.MaxEntries)
+	}
+
+	entries := make([]ArchiveEntry, 0)
+	var total int64
+	for _, f := range r.File {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		err = validateArchiveName(f.Name)
+		if err != nil {
+			return nil, err
+		}
+		if f.Mode().IsRegular() == false || skipArchiveEntry(f.Name) == true {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		entry := ArchiveEntry{Name: f.Name, Filename: fmt.Sprintf("%s/entry-%06d", unpackDir, len(entries))}
+		n, err := writeArchiveEntry(rc, entry.Filename, limits.MaxSize-total)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		total += n
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// unpack the interesting entries of a TAR archive
+func unpackTar(ctx context.Context, inputName string, gzipped bool, unpackDir string, limits ArchiveLimits) ([]ArchiveEntry, error) {
+
+	f, err := os.Open(inputName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var reader io.Reader = f
+	if gzipped == true {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	tr := tar.NewReader(reader)
+	entries := make([]ArchiveEntry, 0)
+	var total int64
+	count := 0
+	for {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		count++
+		if count > limits.MaxEntries {
+			return nil, fmt.Errorf("archive has more than %d entries", limits.MaxEntries)
+		}
+
+		err = validateArchiveName(hdr.Name)
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg || skipArchiveEntry(hdr.Name) == true {
+			continue
+		}
+
+		entry := ArchiveEntry{Name: hdr.Name, Filename: fmt.Sprintf("%s/entry-%06d", unpackDir, len(entries))}
+		n, err := writeArchiveEntry(tr, entry.Filename, limits.MaxSize-total)
+		if err != nil {
+			return nil, err
+		}
+		total += n
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// reject names that try to escape the unpack directory (zip-slip), even though we do not use them
+// to write files, an archive containing them is not one we trust
+func validateArchiveName(name string) error {
+
+	clean := strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(clean, "/") == true || (len(clean) > 1 && clean[1] == ':') {
+		return fmt.Errorf("archive entry [%s] has an absolute path", name)
+	}
+	for _, component := range strings.Split(clean, "/") {
+		if component == ".." {
+			return fmt.Errorf("archive entry [%s] escapes the archive", name)
+		}
+	}
+	return nil
+}
+
+// ignore the clutter some tools add to archives
+func skipArchiveEntry(name string) bool {
+
+	base := path.Base(name)
+	return strings.HasPrefix(name, "__MACOSX/") == true || strings.HasPrefix(base, ".") == true
+}
+
+// write an entry to a file, failing if it is larger than the remaining size allowance. The size
+// recorded in the archive is not trusted
+func writeArchiveEntry(r io.Reader, filename string, remaining int64) (int64, error) {
+
+	out, err := os.Create(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+
+	n, err := io.Copy(out, io.LimitReader(r, remaining+1))
+	if err != nil {
+		return n, err
+	}
+	if n > remaining {
+		return n, fmt.Errorf("archive expands beyond the size limit")
+	}
+	return n, out.Close()
+}
+
+// determine the page order, using the sequence file if the archive includes one
+func archivePageOrder(workerId int, config ServiceConfig, entries []ArchiveEntry) ([]ArchiveEntry, error) {
+
+	// separate the sequence file from the page images
+	var sequence *ArchiveEntry
+	byName := make(map[string]ArchiveEntry)
+	names := make([]string, 0)
+	for ix, e := range entries {
+		if len(config.ArchiveSequenceFile) != 0 && path.Base(e.Name) == config.ArchiveSequenceFile {
+			sequence = &entries[ix]
+			continue
+		}
+		if hasPageSuffix(config, e.Name) == false {
+			log.Printf("[worker %d] INFO: ignoring '%s', not a page image", workerId, e.Name)
+			continue
+		}
+		byName[e.Name] = e
+		names = append(names, e.Name)
+	}
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("archive contains no page images")
+	}
+
+	// no sequence file, order by name
+	if sequence == nil {
+		sorted, err := sortPageNames(config, names)
+		if err != nil {
+			return nil, err
+		}
+		pages := make([]ArchiveEntry, 0, len(sorted))
+		for _, n := range sorted {
+			pages = append(pages, byName[n])
+		}
+		return pages, nil
+	}
+
+	log.Printf("[worker %d] INFO: using sequence file '%s'", workerId, sequence.Name)
+	f, err := os.Open(sequence.Filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// the sequence file names are relative to the directory containing it
+	sequenceDir := path.Dir(sequence.Name)
+	pages := make([]ArchiveEntry, 0)
+	used := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") == true {
+			continue
+		}
+		name := line
+		if sequenceDir != "." {
+			name = path.Join(sequenceDir, line)
+		}
+		e, ok := byName[name]
+		if ok == false {
+			return nil, fmt.Errorf("sequence file lists '%s' which is not in the archive", line)
+		}
+		if used[name] == true {
+			return nil, fmt.Errorf("sequence file lists '%s' more than once", line)
+		}
+		used[name] = true
+		pages = append(pages, e)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, n := range names {
+		if used[n] == false {
+			log.Printf("[worker %d] WARNING: '%s' is not in the sequence file, ignoring it", workerId, n)
+		}
+	}
+
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("sequence file lists no pages")
+	}
+	return pages, nil
+}
+
+// is this one of the configured page image types (used for archive entries and grouped objects)
+func hasPageSuffix(config ServiceConfig, name string) bool {
+
+	ext := strings.ToLower(path.Ext(name))
+	for _, s := range strings.Split(config.ArchivePageSuffixes, ",") {
+		if strings.TrimSpace(strings.ToLower(s)) == ext {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// end of file
+//
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+)
+
+// pdfSplitter - renders each page of a PDF to a TIFF using Ghostscript (or a compatible binary)
+type pdfSplitter struct{}
+
+func (pdfSplitter) Name() string {
+	return "pdf"
+}
+
+func (pdfSplitter) Split(ctx context.Context, workerId int, config ServiceConfig, inputName string) ([]string, error) {
+
+	// split into interesting components
+	dirName := path.Dir(inputName)
+	baseName := path.Base(inputName)
+	baseNoExt := strings.TrimSuffix(baseName, path.Ext(baseName))
+
+	params := []string{
+		"-dSAFER",
+		"-dBATCH",
+		"-dNOPAUSE",
+		"-dQUIET",
+		fmt.Sprintf("-sDEVICE=%s", config.PdfSplitDevice),
+		fmt.Sprintf("-r%d", config.PdfSplitDpi),
+		fmt.Sprintf("-sOutputFile=%s/%s-%%04d.tif", dirName, baseNoExt),
+		inputName,
+	}
+
+	log.Printf("[worker %d] DEBUG: split command \"%s %s\"", workerId, config.PdfSplitBinary, strings.Join(params, " "))
+
+	start := time.Now()
+	output, err := runCommand(ctx, time.Duration(config.SplitTimeout)*time.Second, config.PdfSplitBinary, params)
+	if err != nil {
+		if isTimeout(err) == true {
+			log.Printf("[worker %d] ERROR: TIMEOUT splitting %s (%s)", workerId, inputName, err.Error())
+		} else {
+			log.Printf("[worker %d] ERROR: splitting %s (%s)", workerId, inputName, err.Error())
+		}
+		if len(output) != 0 {
+			log.Printf("[worker %d] ERROR: split output [%s]", workerId, output)
+		}
+		return nil, err
+	}
+
+	duration := time.Since(start)
+	log.Printf("[worker %d] INFO: split complete in %0.2f seconds", workerId, duration.Seconds())
+
+	// identify the pages that were created
+	outputFiles, err := listFiles(workerId, dirName, fmt.Sprintf("%s-", baseNoExt), ".tif")
+	if err != nil {
+		return nil, err
+	}
+	if len(outputFiles) == 0 {
+		return nil, fmt.Errorf("no pages rendered from %s", inputName)
+	}
+	return outputFiles, nil
+}
+
+//
+// end of file
+//
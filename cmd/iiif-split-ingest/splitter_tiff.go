@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// tiffSplitter - splits a multi-page TIFF natively by writing each image file directory (IFD) and the
+// image data it references to a separate single page TIFF. The image data is copied, not re-encoded
+type tiffSplitter struct{}
+
+// TIFF tags we need to understand
+const (
+	tiffTagNewSubfileType        = 254
+	tiffTagStripOffsets          = 273
+	tiffTagStripByteCounts       = 279
+	tiffTagFreeOffsets           = 288
+	tiffTagFreeByteCounts        = 289
+	tiffTagTileOffsets           = 324
+	tiffTagTileByteCounts        = 325
+	tiffTagSubIFDs               = 330
+	tiffTagJPEGInterchangeFormat = 513
+	tiffTagExifIFD               = 34665
+	tiffTagGPSIFD                = 34853
+	tiffTagInteropIFD            = 40965
+)
+
+// TIFF field types
+const (
+	tiffTypeShort = 3
+	tiffTypeLong  = 4
+)
+
+// tags that point at other structures in the source file, these are not carried into the page files
+var tiffDroppedTags = map[uint16]bool{
+	tiffTagFreeOffsets:    true,
+	tiffTagFreeByteCounts: true,
+	tiffTagSubIFDs:        true,
+	tiffTagExifIFD:        true,
+	tiffTagGPSIFD:         true,
+	tiffTagInteropIFD:     true,
+}
+
+// the size in bytes of each TIFF field type
+var tiffTypeSizes = map[uint16]uint32{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8, 13: 4,
+}
+
+// the maximum number of pages we are prepared to read (protects against IFD loops)
+var tiffMaxPages = 100000
+
+// the largest tag value we are prepared to read (protects against corrupt files)
+var tiffMaxValueSize = uint64(64 * 1024 * 1024)
+
+// TiffEntry - a single IFD entry
+type TiffEntry struct {
+	Tag   uint16 // the tag
+	Type  uint16 // the field type
+	Count uint32 // the number of values
+	Value []byte // the value bytes (in the file byte order)
+}
+
+// TiffPage - the entries of a single IFD
+type TiffPage struct {
+	Entries []TiffEntry
+}
+
+func (tiffSplitter) Name() string {
+	return "tiff"
+}
+
+// the pages are written in IFD order
+func (tiffSplitter) Sequenced() bool {
+	return true
+}
+
+func (tiffSplitter) Split(ctx context.Context, workerId int, config ServiceConfig, inputName string) ([]string, error) {
+
+	start := time.Now()
+
+	f, err := os.Open(inputName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	order, pages, err := readTiffPages(f)
+	if err != nil {
+		log.Printf("[worker %d] ERROR: reading %s (%s)", workerId, inputName, err.Error())
+		return nil, err
+	}
+
+	// reduced resolution images (thumbnails) are not pages
+	pages = removeTiffThumbnails(order, pages)
+
+	// nothing to split
+	if len(pages) == 1 {
+		log.Printf("[worker %d] INFO: %s is a single page", workerId, inputName)
+		return []string{inputName}, nil
+	}
+
+	dirName := path.Dir(inputName)
+	baseName := path.Base(inputName)
+	baseNoExt := strings.TrimSuffix(baseName, path.Ext(baseName))
+
+	outputFiles := make([]string, 0, len(pages))
+	for ix, page := range pages {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		pageName := fmt.Sprintf("%s/%s-%04d.tif", dirName, baseNoExt, ix+1)
+		err = writeTiffPage(f, order, page, pageName)
+		if err != nil {
+			log.Printf("[worker %d] ERROR: writing page %d of %s (%s)", workerId, ix+1, inputName, err.Error())
+			return nil, err
+		}
+		outputFiles = append(outputFiles, pageName)
+	}
+
+	duration := time.Since(start)
+	log.Printf("[worker %d] INFO: split %d pages in %0.2f seconds", workerId, len(outputFiles), duration.Seconds())
+	return outputFiles, nil
+}
+
+// is this the header of a (classic, not BigTIFF) TIFF file
+func isTiffHeader(buf []byte) bool {
+	return bytes.HasPrefix(buf, []byte("II*\x00")) || bytes.HasPrefix(buf, []byte("MM\x00*"))
+}
+
+// read the entries of every IFD in the file
+func readTiffPages(r io.ReaderAt) (binary.ByteOrder, []TiffPage, error) {
+
+	header := make([]byte, 8)
+	_, err := r.ReadAt(header, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading TIFF header (%s)", err.Error())
+	}
+	if isTiffHeader(header) == false {
+		return nil, nil, fmt.Errorf("not a TIFF file (or an unsupported BigTIFF)")
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	if header[0] == 'M' {
+		order = binary.BigEndian
+	}
+
+	pages := make([]TiffPage, 0)
+	offset := order.Uint32(header[4:])
+	for offset != 0 {
+		if len(pages) >= tiffMaxPages {
+			return nil, nil, fmt.Errorf("too many pages (or an IFD loop)")
+		}
+
+		page, next, err := readTiffIFD(r, order, offset)
+		if err != nil {
+			return nil, nil, err
+		}
+		pages = append(pages, page)
+		offset = next
+	}
+
+	if len(pages) == 0 {
+		return nil, nil, fmt.Errorf("TIFF file contains no images")
+	}
+	return order, pages, nil
+}
+
+// read a single IFD, returns the page and the offset of the next IFD
+func readTiffIFD(r io.ReaderAt, order binary.ByteOrder, offset uint32) (TiffPage, uint32, error) {
+
+	page := TiffPage{}
+	buf := make([]byte, 2)
+	_, err := r.ReadAt(buf, int64(offset))
+	if err != nil {
+		return page, 0, fmt.Errorf("reading IFD at %d (%s)", offset, err.Error())
+	}
+	count := int(order.Uint16(buf))
+
+	buf = make([]byte, count*12+4)
+	_, err = r.ReadAt(buf, int64(offset)+2)
+	if err != nil {
+		return page, 0, fmt.Errorf("reading IFD at %d (%s)", offset, err.Error())
+	}
+
+	page.Entries = make([]TiffEntry, 0, count)
+	for i := 0; i < count; i++ {
+		e := buf[i*12 : (i+1)*12]
+		entry := TiffEntry{Tag: order.Uint16(e[0:]), Type: order.Uint16(e[2:]), Count: order.Uint32(e[4:])}
+
+		// old style JPEG images point at data we cannot safely relocate
+		if entry.Tag == tiffTagJPEGInterchangeFormat {
+			return page, 0, fmt.Errorf("old style JPEG compressed TIFF files are not supported")
+		}
+		if tiffDroppedTags[entry.Tag] == true {
+			continue
+		}
+
+		size, ok := tiffTypeSizes[entry.Type]
+		if ok == false {
+			// an unknown type, we cannot know how big the value is
+			continue
+		}
+
+		length := uint64(size) * uint64(entry.Count)
+		if length > tiffMaxValueSize {
+			return page, 0, fmt.Errorf("tag %d value is too large", entry.Tag)
+		}
+		if length <= 4 {
+			entry.Value = append([]byte{}, e[8:8+length]...)
+		} else {
+			entry.Value = make([]byte, length)
+			_, err = r.ReadAt(entry.Value, int64(order.Uint32(e[8:])))
+			if err != nil {
+				return page, 0, fmt.Errorf("reading tag %d value (%s)", entry.Tag, err.Error())
+			}
+		}
+		page.Entries = append(page.Entries, entry)
+	}
+
+	return page, order.Uint32(buf[count*12:]), nil
+}
+
+// remove any reduced resolution images from the list of pages
+func removeTiffThumbnails(order binary.ByteOrder, pages []TiffPage) []TiffPage {
+
+	result := make([]TiffPage, 0, len(pages))
+	for _, page := range pages {
+		thumbnail := false
+		for _, e := range page.Entries {
+			if e.Tag == tiffTagNewSubfileType {
+				values := tiffEntryValues(order, e)
+				thumbnail = len(values) != 0 && values[0]&1 != 0
+			}
+		}
+		if thumbnail == false {
+			result = append(result, page)
+		}
+	}
+
+	// never remove everything
+	if len(result) == 0 {
+		return pages
+	}
+	return result
+}
+
+// get the values of an integer entry
+func tiffEntryValues(order binary.ByteOrder, entry TiffEntry) []uint32 {
+
+	values := make([]uint32, 0, entry.Count)
+	for i := uint32(0); i < entry.Count; i++ {
+		switch entry.Type {
+		case tiffTypeShort:
+			values = append(values, uint32(order.Uint16(entry.Value[i*2:])))
+		case tiffTypeLong:
+			values = append(values, order.Uint32(entry.Value[i*4:]))
+		}
+	}
+	return values
+}
+
+// write a single page TIFF containing the specified IFD and the image data it references
+func writeTiffPage(r io.ReaderAt, order binary.ByteOrder, page TiffPage, filename string) error {
+
+	// find the image data, it is either in strips or tiles
+	offsetsIx, countsIx := -1, -1
+	for ix, e := range page.Entries {
+		switch e.Tag {
+		case tiffTagStripOffsets, tiffTagTileOffsets:
+			offsetsIx = ix
+		case tiffTagStripByteCounts, tiffTagTileByteCounts:
+			countsIx = ix
+		}
+	}
+	if offsetsIx == -1 || countsIx == -1 {
+		return fmt.Errorf("page has no image data")
+	}
+
+	srcOffsets := tiffEntryValues(order, page.Entries[offsetsIx])
+	byteCounts := tiffEntryValues(order, page.Entries[countsIx])
+	if len(srcOffsets) == 0 || len(srcOffsets) != len(byteCounts) {
+		return fmt.Errorf("page has inconsistent image data offsets")
+	}
+
+	// the image data offsets will change so always write them as longs
+	offsets := &page.Entries[offsetsIx]
+	offsets.Type = tiffTypeLong
+	offsets.Value = make([]byte, 4*len(srcOffsets))
+
+	// layout: header, IFD, out of line values, image data
+	ifdSize := uint32(2 + 12*len(page.Entries) + 4)
+	valueOffset := 8 + ifdSize
+	valueOffsets := make([]uint32, len(page.Entries))
+	for ix, e := range page.Entries {
+		if len(e.Value) > 4 {
+			valueOffsets[ix] = valueOffset
+			valueOffset += uint32(len(e.Value))
+			valueOffset += valueOffset % 2 // values start on a word boundary
+		}
+	}
+
+	dataOffset := valueOffset
+	for ix, count := range byteCounts {
+		order.PutUint32(offsets.Value[ix*4:], dataOffset)
+		dataOffset += count
+	}
+
+	// and write it all out
+	out, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	var buf bytes.Buffer
+	if order == binary.BigEndian {
+		buf.WriteString("MM\x00*")
+	} else {
+		buf.WriteString("II*\x00")
+	}
+	_ = binary.Write(&buf, order, uint32(8))
+
+	_ = binary.Write(&buf, order, uint16(len(page.Entries)))
+	for ix, e := range page.Entries {
+		_ = binary.Write(&buf, order, e.Tag)
+		_ = binary.Write(&buf, order, e.Type)
+		_ = binary.Write(&buf, order, e.Count)
+		if len(e.Value) > 4 {
+			_ = binary.Write(&buf, order, valueOffsets[ix])
+		} else {
+			inline := make([]byte, 4)
+			copy(inline, e.Value)
+			buf.Write(inline)
+		}
+	}
+	_ = binary.Write(&buf, order, uint32(0))
+
+	for _, e := range page.Entries {
+		if len(e.Value) > 4 {
+			buf.Write(e.Value)
+			if len(e.Value)%2 != 0 {
+				buf.WriteByte(0)
+			}
+		}
+	}
+
+	_, err = out.Write(buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	for ix, count := range byteCounts {
+		_, err = io.CopyN(out, io.NewSectionReader(r, int64(srcOffsets[ix]), int64(count)), int64(count))
+		if err != nil {
+			return err
+		}
+	}
+
+	return out.Close()
+}
+
+//
+// end of file
+//
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// the variables available to each command template
+var splitTemplateVariables = []string{"in", "out", "id", "workdir", "dpi"}
+var convertTemplateVariables = []string{"in", "out", "id", "page", "workdir", "dpi"}
+var decodeTemplateVariables = []string{"in"}
+
+// build the arguments for a command from its template. The template is split into arguments using shell
+// style quoting and then any {name} variables are replaced, so a value containing spaces is always a single
+// argument. Use {{ and }} for literal braces
+func buildCommandArgs(template string, vars map[string]string) ([]string, error) {
+
+	args, err := splitTemplateArgs(template)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, len(args))
+	for _, a := range args {
+		expanded, err := expandTemplateArg(a, vars)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, expanded)
+	}
+	return result, nil
+}
+
+// validate a command template, it must parse and only refer to the allowed variables
+func validateCommandTemplate(template string, allowed []string) error {
+
+	vars := make(map[string]string)
+	for _, v := range allowed {
+		vars[v] = v
+	}
+
+	args, err := buildCommandArgs(template, vars)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("command template is empty")
+	}
+	return nil
+}
+
+// replace the legacy placeholder tokens (every occurrence) with the equivalent template variables
+func legacyCommandTemplate(template string, inToken string, outToken string) string {
+
+	if len(inToken) != 0 {
+		template = strings.ReplaceAll(template, inToken, "{in}")
+	}
+	if len(outToken) != 0 {
+		template = strings.ReplaceAll(template, outToken, "{out}")
+	}
+	return template
+}
+
+// split a template into arguments. Arguments are separated by whitespace, single quotes preserve
+// everything up to the closing quote, double quotes allow \" and \\ escapes and a backslash outside
+// of quotes escapes the next character
+func splitTemplateArgs(template string) ([]string, error) {
+
+	args := make([]string, 0)
+	var current strings.Builder
+	inArg := false
+	quote := rune(0)
+	escaped := false
+
+	for _, c := range template {
+		switch {
+		case escaped == true:
+			current.WriteRune(c)
+			escaped = false
+
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(c)
+			}
+
+		case quote == '"':
+			switch c {
+			case '"':
+				quote = 0
+			case '\\':
+				escaped = true
+			default:
+				current.WriteRune(c)
+			}
+
+		case c == '\'' || c == '"':
+			quote = c
+			inArg = true
+
+		case c == '\\':
+			escaped = true
+			inArg = true
+
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg == true {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+
+		default:
+			current.WriteRune(c)
+			inArg = true
+		}
+	}
+
+	if escaped == true {
+		return nil, fmt.Errorf("command template ends with an escape")
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("command template has an unterminated %c quote", quote)
+	}
+	if inArg == true {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// replace the {name} variables in a single argument
+func expandTemplateArg(arg string, vars map[string]string) (string, error) {
+
+	var result strings.Builder
+	for len(arg) != 0 {
+		switch {
+		case strings.HasPrefix(arg, "{{"):
+			result.WriteString("{")
+			arg = arg[2:]
+
+		case strings.HasPrefix(arg, "}}"):
+			result.WriteString("}")
+			arg = arg[2:]
+
+		case arg[0] == '{':
+			end := strings.IndexByte(arg, '}')
+			if end == -1 {
+				return "", fmt.Errorf("unterminated variable in [%s]", arg)
+			}
+			name := arg[1:end]
+			value, ok := vars[name]
+			if ok == false {
+				return "", fmt.Errorf("unknown variable {%s}", name)
+			}
+			result.WriteString(value)
+			arg = arg[end+1:]
+
+		default:
+			result.WriteByte(arg[0])
+			arg = arg[1:]
+		}
+	}
+	return result.String(), nil
+}
+
+//
+// end of file
+//
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/barasher/go-exiftool"
+)
+
+// ErrInvalidImage - a conversion produced an image we will not publish
+var ErrInvalidImage = errors.New("invalid converted image")
+
+// ImageValidationError - the reason a converted page failed validation
+type ImageValidationError struct {
+	Page     int    // the page number
+	Filename string // the converted file
+	Reason   string // what is wrong with it
+}
+
+func (e *ImageValidationError) Error() string {
+	return fmt.Sprintf("page %d (%s): %s", e.Page, path.Base(e.Filename), e.Reason)
+}
+
+func (e *ImageValidationError) Unwrap() error {
+	return ErrInvalidImage
+}
+
+// the MIME types of the image formats we expect to create, anything else is looked up
+var suffixMimeTypes = map[string]string{
+	"jp2":  "image/jp2",
+	"jpx":  "image/jpx",
+	"jpg":  "image/jpeg",
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"tif":  "image/tiff",
+	"tiff": "image/tiff",
+	"ptif": "image/tiff",
+	"gif":  "image/gif",
+	"webp": "image/webp",
+}
+
+// ErrInspector - we could not inspect an image, this is a problem with exiftool rather than the image
+var ErrInspector = errors.New("image inspection failed")
+
+// classify an exiftool error. Output it cannot return or we cannot parse is caused by the image content and
+// will not go away, anything else is a problem with exiftool itself (it could not be started or has died)
+func exiftoolError(err error) error {
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.Is(err, exiftool.ErrBufferTooSmall) == true ||
+		errors.Is(err, exiftool.ErrNotExist) == true ||
+		errors.Is(err, exiftool.ErrNotFile) == true ||
+		errors.As(err, &syntaxErr) == true ||
+		errors.As(err, &typeErr) == true {
+		return fmt.Errorf("%w: %s", ErrInvalidImage, err.Error())
+	}
+	return fmt.Errorf("%w: %s", ErrInspector, err.Error())
+}
+
+// exiftool is expensive to start so instances are reused, each can only handle one file at a time. The pool
+// holds one slot for every page that can be converted at once, a nil slot has not started exiftool yet (or
+// the instance failed and must be replaced)
+type imageInspectors struct {
+	sync.Once
+	pool chan *exiftool.Exiftool
+}
+
+var inspectors imageInspectors
+
+// extract the metadata of an image using an instance from the pool, starting exiftool if necessary
+func (i *imageInspectors) inspect(config ServiceConfig, filename string) (exiftool.FileMetadata, error) {
+
+	i.Do(func() {
+		size := config.Workers * config.PageWorkers
+		if size < 1 {
+			size = 1
+		}
+		i.pool = make(chan *exiftool.Exiftool, size)
+		for n := 0; n < size; n++ {
+			i.pool <- nil
+		}
+	})
+
+	et := <-i.pool
+	if et == nil {
+		var err error
+		et, err = exiftool.NewExiftool()
+		if err != nil {
+			i.pool <- nil
+			return exiftool.FileMetadata{}, fmt.Errorf("%w: starting exiftool (%s)", ErrInspector, err.Error())
+		}
+	}
+
+	// problems reading a file are usually reported as fields. After an error the instance may be out of step
+	// with its output so we replace it
+	infos := et.ExtractMetadata(filename)
+	if len(infos) == 0 || infos[0].Err != nil {
+		_ = et.Close()
+		i.pool <- nil
+		if len(infos) == 0 {
+			return exiftool.FileMetadata{}, fmt.Errorf("%w: no metadata returned for %s", ErrInspector, filename)
+		}
+		return exiftool.FileMetadata{}, exiftoolError(infos[0].Err)
+	}
+
+	i.pool <- et
+	return infos[0], nil
+}
+
+// the MIME type we expect for a file with the specified suffix (empty if we do not know)
+func expectedMimeType(suffix string) string {
+
+	suffix = strings.ToLower(strings.TrimPrefix(suffix, "."))
+	if mimeType, ok := suffixMimeTypes[suffix]; ok == true {
+		return mimeType
+	}
+	mimeType, _, _ := strings.Cut(mime.TypeByExtension(fmt.Sprintf(".%s", suffix)), ";")
+	return strings.TrimSpace(mimeType)
+}
+
+// check a converted page is an image we are prepared to publish, the conversion exit status is not enough.
+// The existence, size and type checks apply to every output, the dimension bounds only to the primary one.
+// exiftool only reads the image headers so a truncated image is only detected if a decode command is configured
+func validateImage(ctx context.Context, workerId int, config ServiceConfig, page int, filename string, primary bool) error {
+
+	if config.ValidateOutput == false {
+		return nil
+	}
+
+	invalid := func(reason string) error {
+		err := &ImageValidationError{Page: page, Filename: filename, Reason: reason}
+		log.Printf("[worker %d] ERROR: validation failed for %s", workerId, err.Error())
+		return err
+	}
+
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return invalid("converted file does not exist")
+	}
+	if fi.Size() == 0 {
+		return invalid("converted file is empty")
+	}
+
+	info, err := inspectors.inspect(config, filename)
+	if err != nil {
+		if errors.Is(err, ErrInvalidImage) == true {
+			return invalid(fmt.Sprintf("cannot be inspected (%s)", err.Error()))
+		}
+		// a problem with exiftool rather than the image
+		log.Printf("[worker %d] ERROR: inspecting %s (%s)", workerId, filename, err.Error())
+		return err
+	}
+	if problem, e := info.GetString("Error"); e == nil && len(problem) != 0 {
+		return invalid(fmt.Sprintf("cannot be read (%s)", problem))
+	}
+
+	// the image type must be the one the suffix says it is
+	mimeType, _ := info.GetString("MIMEType")
+	expected := expectedMimeType(path.Ext(filename))
+	if len(expected) != 0 && mimeType != expected {
+		return invalid(fmt.Sprintf("type is %s, expected %s", mimeType, expected))
+	}
+
+	width, height := imageDimension(info, "ImageWidth"), imageDimension(info, "ImageHeight")
+	if width <= 0 || height <= 0 {
+		return invalid("image dimensions are unknown")
+	}
+
+	// a full decode of the image (if configured)
+	if len(config.ValidateDecodeBinary) != 0 {
+		err = decodeImage(ctx, workerId, config, filename)
+		if err != nil {
+			if errors.Is(err, ErrCommandTimeout) == true || ctx.Err() != nil {
+				return err
+			}
+			return invalid(fmt.Sprintf("cannot be decoded (%s)", err.Error()))
+		}
+	}
+
+	if primary == false {
+		log.Printf("[worker %d] DEBUG: page %d rendition is a valid %s (%dx%d)", workerId, page, mimeType, width, height)
+		return nil
+	}
+	if width < config.ValidateMinWidth {
+		return invalid(fmt.Sprintf("image width is %d, the minimum is %d", width, config.ValidateMinWidth))
+	}
+	if height < config.ValidateMinHeight {
+		return invalid(fmt.Sprintf("image height is %d, the minimum is %d", height, config.ValidateMinHeight))
+	}
+	if config.ValidateMaxWidth != 0 && width > config.ValidateMaxWidth {
+		return invalid(fmt.Sprintf("image width is %d, the maximum is %d", width, config.ValidateMaxWidth))
+	}
+	if config.ValidateMaxHeight != 0 && height > config.ValidateMaxHeight {
+		return invalid(fmt.Sprintf("image height is %d, the maximum is %d", height, config.ValidateMaxHeight))
+	}
+
+	log.Printf("[worker %d] DEBUG: page %d is a valid %s (%dx%d)", workerId, page, mimeType, width, height)
+	return nil
+}
+
+// decode the image using the configured command, a non-zero exit status means it cannot be decoded
+func decodeImage(ctx context.Context, workerId int, config ServiceConfig, filename string) error {
+
+	params, err := buildCommandArgs(config.ValidateDecodeCommandLine, map[string]string{"in": filename})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[worker %d] DEBUG: decode command \"%s %s\"", workerId, config.ValidateDecodeBinary, strings.Join(params, " "))
+	output, err := runCommand(ctx, time.Duration(config.ConvertPageTimeout)*time.Second, config.ValidateDecodeBinary, params)
+	if err != nil {
+		if len(output) != 0 {
+			log.Printf("[worker %d] ERROR: decode output [%s]", workerId, strings.TrimSpace(string(output)))
+		}
+		return err
+	}
+	return nil
+}
+
+// get an image dimension, exiftool reports these as numbers or strings depending on the format
+func imageDimension(info exiftool.FileMetadata, field string) int {
+
+	value, err := info.GetString(field)
+	if err != nil {
+		return 0
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+//
+// end of file
+//
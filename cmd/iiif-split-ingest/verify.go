@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// re-check the outputs recorded in the fixity sidecars under a directory or bucket prefix and report any changes
+func verifyCommand(args []string) int {
+
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	dir := flags.String("dir", "", "the output directory to verify")
+	bucket := flags.String("bucket", "", "the output bucket to verify (instead of a directory)")
+	prefix := flags.String("prefix", "", "the key prefix to verify")
+	err := flags.Parse(args)
+	if err != nil {
+		return exitUsage
+	}
+
+	if (len(*dir) == 0) == (len(*bucket) == 0) {
+		fmt.Fprintf(os.Stderr, "verify: specify either -dir or -bucket\n")
+		flags.Usage()
+		return exitUsage
+	}
+
+	// locate the sidecars
+	var sidecars []string
+	if len(*dir) != 0 {
+		sidecars, err = findFixitySidecars(*dir)
+	} else {
+		sidecars, err = findBucketFixitySidecars(*bucket, *prefix)
+	}
+	if err != nil {
+		return exitFailure
+	}
+	log.Printf("[main] INFO: verifying %d fixity sidecar(s)", len(sidecars))
+
+	files := 0
+	failures := 0
+	for _, sidecar := range sidecars {
+		record, err := loadFixityRecord(*bucket, sidecar)
+		if err != nil {
+			fmt.Printf("FAILED:   %s (%s)\n", sidecar, err.Error())
+			failures++
+			continue
+		}
+
+		for _, f := range record.Files {
+			files++
+			location := fmt.Sprintf("%s/%s", path.Dir(sidecar), f.Filename)
+			err = verifyFixity(*bucket, location, f)
+			if err != nil {
+				fmt.Printf("FAILED:   %s (%s)\n", location, err.Error())
+				failures++
+				continue
+			}
+			fmt.Printf("OK:       %s\n", location)
+		}
+	}
+
+	fmt.Printf("verified: %d file(s) in %d sidecar(s)\n", files, len(sidecars))
+	fmt.Printf("failed:   %d\n", failures)
+
+	if failures != 0 {
+		return exitFailure
+	}
+	return exitSuccess
+}
+
+// locate the fixity sidecars in a directory tree
+func findFixitySidecars(dir string) ([]string, error) {
+
+	sidecars := make([]string, 0)
+	err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() == false && d.Name() == fixityJSONName {
+			sidecars = append(sidecars, name)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("[main] ERROR: searching %s (%s)", dir, err.Error())
+		return nil, err
+	}
+	return sidecars, nil
+}
+
+// locate the fixity sidecars under a bucket prefix
+func findBucketFixitySidecars(bucket string, prefix string) ([]string, error) {
+
+	objects, err := listBucketObjects(bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	sidecars := make([]string, 0)
+	for _, o := range objects {
+		if path.Base(o.Key) == fixityJSONName {
+			sidecars = append(sidecars, o.Key)
+		}
+	}
+	return sidecars, nil
+}
+
+// load a fixity sidecar from the filesystem or, if a bucket is specified, from the bucket
+func loadFixityRecord(bucket string, location string) (*FixityRecord, error) {
+
+	var b []byte
+	var err error
+	if len(bucket) == 0 {
+		b, err = os.ReadFile(location)
+	} else {
+		var obj io.ReadCloser
+		obj, err = openS3Object(bucket, location, nil)
+		if err == nil {
+			b, err = io.ReadAll(obj)
+			obj.Close()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	record := &FixityRecord{}
+	err = json.Unmarshal(b, record)
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// recalculate the checksums of a file and compare them with the recorded ones
+func verifyFixity(bucket string, location string, expected Fixity) error {
+
+	var r io.ReadCloser
+	var err error
+	metadata := make(map[string]string)
+	if len(bucket) == 0 {
+		r, err = os.Open(location)
+	} else {
+		r, err = openS3Object(bucket, location, metadata)
+	}
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	size, md5sum, sha256sum, err := readerChecksums(r)
+	if err != nil {
+		return err
+	}
+
+	if size != expected.Size {
+		return fmt.Errorf("size is %d, expected %d", size, expected.Size)
+	}
+	if sha256sum != expected.SHA256 {
+		return fmt.Errorf("SHA-256 is %s, expected %s", sha256sum, expected.SHA256)
+	}
+	if md5sum != expected.MD5 {
+		return fmt.Errorf("MD5 is %s, expected %s", md5sum, expected.MD5)
+	}
+
+	// the object metadata must agree with the sidecar too
+	if value, ok := metadata[sha256MetadataKey]; ok == true && value != expected.SHA256 {
+		return fmt.Errorf("SHA-256 metadata is %s, expected %s", value, expected.SHA256)
+	}
+	if value, ok := metadata[md5MetadataKey]; ok == true && value != expected.MD5 {
+		return fmt.Errorf("MD5 metadata is %s, expected %s", value, expected.MD5)
+	}
+
+	return nil
+}
+
+// open an S3 object for reading, the object metadata is returned in the map (if provided)
+func openS3Object(bucket string, key string, metadata map[string]string) (io.ReadCloser, error) {
+
+	obj, err := getS3Object(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if metadata != nil {
+		for k, v := range obj.Metadata {
+			metadata[strings.ToLower(k)] = strings.ToLower(aws.StringValue(v))
+		}
+	}
+	return obj.Body, nil
+}
+
+//
+// end of file
+//
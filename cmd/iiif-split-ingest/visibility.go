@@ -0,0 +1,110 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/uvalib/virgo4-sqs-sdk/awssqs"
+)
+
+// VisibilityExtender - keeps in-flight inbound messages invisible while they are being processed
+type VisibilityExtender struct {
+	svc      *sqs.SQS           // the SQS service (the awssqs helper does not support visibility changes)
+	queue    awssqs.QueueHandle // the inbound queue
+	timeout  int64              // the visibility timeout to apply (in seconds)
+	interval time.Duration      // how often to apply it
+}
+
+// create the visibility extender, the heartbeat is disabled if the visibility timeout is not configured
+func newVisibilityExtender(config ServiceConfig, queue awssqs.QueueHandle) (*VisibilityExtender, error) {
+
+	sess, err := awsSession()
+	if err != nil {
+		return nil, err
+	}
+
+	return &VisibilityExtender{
+		svc:      sqs.New(sess),
+		queue:    queue,
+		timeout:  int64(config.VisibilityTimeout),
+		interval: time.Duration(config.VisibilityHeartbeat) * time.Second,
+	}, nil
+}
+
+// start a heartbeat that periodically extends the visibility of the specified message. Returns the
+// function used to stop the heartbeat
+func (v *VisibilityExtender) start(receiptHandle awssqs.ReceiptHandle) func() {
+
+	// nothing to do if we are not configured
+	if v == nil || v.timeout == 0 || len(receiptHandle) == 0 {
+		return func() {}
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		// extend immediately in case the queue default is shorter than our heartbeat
+		v.extend(receiptHandle)
+
+		ticker := time.NewTicker(v.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				v.extend(receiptHandle)
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// make the message visible again immediately so it can be redelivered
+func (v *VisibilityExtender) release(receiptHandle awssqs.ReceiptHandle) {
+
+	if v == nil || len(receiptHandle) == 0 {
+		return
+	}
+
+	err := v.changeVisibility(receiptHandle, 0)
+	if err != nil {
+		log.Printf("[main] WARNING: failed to release message (%s)", err.Error())
+		return
+	}
+	log.Printf("[main] INFO: released message for redelivery")
+}
+
+// extend the visibility timeout of the message, failures are logged but otherwise ignored
+func (v *VisibilityExtender) extend(receiptHandle awssqs.ReceiptHandle) {
+
+	err := v.changeVisibility(receiptHandle, v.timeout)
+	if err != nil {
+		log.Printf("[main] WARNING: failed to extend message visibility (%s)", err.Error())
+		return
+	}
+	log.Printf("[main] DEBUG: extended message visibility by %d seconds", v.timeout)
+}
+
+func (v *VisibilityExtender) changeVisibility(receiptHandle awssqs.ReceiptHandle, timeout int64) error {
+
+	_, err := v.svc.ChangeMessageVisibility(&sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(string(v.queue)),
+		ReceiptHandle:     aws.String(string(receiptHandle)),
+		VisibilityTimeout: aws.Int64(timeout),
+	})
+	return err
+}
+
+//
+// end of file
+//
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// the subdirectories of the watch directory that processed files are moved to
+var watchDoneDir = "done"
+var watchFailedDir = "failed"
+var watchReasonSuffix = ".reason"
+
+// the state of a file in the watch directory, used to decide when it has been completely written
+type watchedFile struct {
+	size    int64
+	modTime time.Time
+}
+
+// poll the watch directory and send a notification for each new file once it has stopped changing,
+// returns when we are asked to stop
+func watchDirectory(ctx context.Context, config ServiceConfig, notifies chan<- Notify) {
+
+	// files we have seen but that may still be being written
+	pending := make(map[string]watchedFile)
+	// files that have been sent to a worker
+	queued := make(map[string]bool)
+
+	interval := time.Duration(config.WatchPollInterval) * time.Second
+	for sleepUnlessStopped(ctx, interval) == true {
+		entries, err := os.ReadDir(config.WatchDir)
+		if err != nil {
+			log.Printf("[main] ERROR: listing watch directory %s (%s), sleeping and retrying", config.WatchDir, err.Error())
+			continue
+		}
+
+		present := make(map[string]bool)
+		for _, e := range entries {
+
+			// ignore directories (including our done and failed ones) and hidden files
+			if e.IsDir() == true || strings.HasPrefix(e.Name(), ".") == true {
+				continue
+			}
+
+			name := e.Name()
+			present[name] = true
+			if queued[name] == true {
+				continue
+			}
+
+			info, err := e.Info()
+			if err != nil {
+				continue
+			}
+
+			// only process the file once it is the same as the last time we looked
+			current := watchedFile{size: info.Size(), modTime: info.ModTime()}
+			previous, found := pending[name]
+			if found == false || previous != current {
+				pending[name] = current
+				continue
+			}
+
+			log.Printf("[main] INFO: new file %s in watch directory", name)
+			delete(pending, name)
+			queued[name] = true
+
+			notify := Notify{
+				SourceBucket: config.WatchDir,
+				BucketKey:    name,
+				ExpectedSize: current.size,
+				LocalFile:    fmt.Sprintf("%s/%s", config.WatchDir, name),
+			}
+			select {
+			case notifies <- notify:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		// forget files that are no longer there, processed files are moved away
+		for name := range queued {
+			if present[name] == false {
+				delete(queued, name)
+			}
+		}
+		for name := range pending {
+			if present[name] == false {
+				delete(pending, name)
+			}
+		}
+	}
+}
+
+// move a processed file to the done or failed subdirectory of the watch directory
+func finishLocalFile(workerId int, config ServiceConfig, localFile string, jobErr error) error {
+
+	subDir := watchDoneDir
+	if jobErr != nil {
+		subDir = watchFailedDir
+	}
+
+	// dont overwrite an earlier file with the same name
+	newName := fmt.Sprintf("%s/%s/%s", config.WatchDir, subDir, path.Base(localFile))
+	if fileExists(newName) == true {
+		newName = fmt.Sprintf("%s.%s", newName, time.Now().Format("20060102150405"))
+	}
+
+	log.Printf("[worker %d] INFO: moving '%s' -> '%s'", workerId, localFile, newName)
+	err := os.Rename(localFile, newName)
+	if err != nil {
+		log.Printf("[worker %d] ERROR: failed to move '%s' (%s)", workerId, localFile, err.Error())
+		return err
+	}
+
+	// record why a failed file failed alongside it
+	if jobErr != nil {
+		reasonFile := fmt.Sprintf("%s%s", newName, watchReasonSuffix)
+		err = writeFile(reasonFile, fmt.Sprintf("%s\n", jobErr.Error()))
+		if err != nil {
+			log.Printf("[worker %d] WARNING: failed to write '%s' (%s)", workerId, reasonFile, err.Error())
+		}
+	}
+
+	return nil
+}
+
+//
+// end of file
+//
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// the job started event type (the others are shared with the outbound queue)
+var eventTypeStarted = "ingest.started"
+
+// the webhook request headers
+var webhookEventHeader = "X-Ingest-Event"
+var webhookTimestampHeader = "X-Ingest-Timestamp"
+var webhookSignatureHeader = "X-Ingest-Signature"
+
+// the number of events waiting for delivery before we start dropping them
+var webhookQueueSize = 256
+
+// WebhookNotifier - delivers signed job lifecycle events to the configured endpoints. Delivery happens in
+// the background so a slow or failing receiver never holds up a worker
+type WebhookNotifier struct {
+	client    *http.Client     // the http client
+	endpoints []string         // the endpoint URLs
+	secret    []byte           // the shared secret used to sign the payloads
+	queue     chan webhookItem // the events waiting for delivery
+	pending   int64            // the number of events queued or being delivered (updated atomically)
+	done      chan struct{}    // closed once the queue has been drained
+}
+
+// an event waiting for delivery
+type webhookItem struct {
+	workerId int    // the worker that sent the event (for logging)
+	event    string // the event type
+	payload  []byte // the event payload
+}
+
+// create the webhook notifier, returns nil if no endpoints are configured
+func newWebhookNotifier(config ServiceConfig) *WebhookNotifier {
+
+	if len(config.WebhookEndpoints) == 0 {
+		return nil
+	}
+
+	endpoints := make([]string, 0)
+	for _, e := range strings.Split(config.WebhookEndpoints, ",") {
+		e = strings.TrimSpace(e)
+		if len(e) != 0 {
+			endpoints = append(endpoints, e)
+		}
+	}
+
+	w := &WebhookNotifier{
+		client:    newHttpClient(len(endpoints), config.WebhookTimeout),
+		endpoints: endpoints,
+		secret:    []byte(config.WebhookSecret),
+		queue:     make(chan webhookItem, webhookQueueSize),
+		done:      make(chan struct{}),
+	}
+
+	// events are delivered in order by a single goroutine
+	go func() {
+		defer close(w.done)
+		for item := range w.queue {
+			w.deliver(item)
+			atomic.AddInt64(&w.pending, -1)
+		}
+	}()
+
+	return w
+}
+
+// stop accepting events and wait for the ones already queued to be delivered, anything not delivered by the
+// deadline is dropped
+func (w *WebhookNotifier) stop(deadline time.Time) {
+
+	if w == nil {
+		return
+	}
+	close(w.queue)
+
+	select {
+	case <-w.done:
+		return
+	case <-time.After(time.Until(deadline)):
+	}
+
+	// it may have finished just as we gave up
+	dropped := atomic.LoadInt64(&w.pending)
+	if dropped != 0 {
+		log.Printf("[main] WARNING: shutdown grace period expired, dropping %d undelivered webhook event(s)", dropped)
+	}
+}
+
+// create the job started event
+func newStartedEvent(notify Notify, start time.Time) CompletionEvent {
+
+	return CompletionEvent{
+		Event:        eventTypeStarted,
+		SourceBucket: notify.SourceBucket,
+		SourceKey:    notify.BucketKey,
+		Outputs:      make([]string, 0),
+		Started:      start.UTC().Format(time.RFC3339),
+	}
+}
+
+// queue an event for delivery to each endpoint, nothing happens if we are not configured. The event is
+// dropped if the queue is full, failures do not affect the outcome of the job
+func (w *WebhookNotifier) notify(workerId int, event CompletionEvent) {
+
+	if w == nil {
+		return
+	}
+
+	b, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("[worker %d] ERROR: json marshal (%s)", workerId, err.Error())
+		return
+	}
+
+	atomic.AddInt64(&w.pending, 1)
+	select {
+	case w.queue <- webhookItem{workerId: workerId, event: event.Event, payload: b}:
+	default:
+		atomic.AddInt64(&w.pending, -1)
+		log.Printf("[worker %d] ERROR: webhook queue full, dropping %s event for %s", workerId, event.Event, event.SourceKey)
+	}
+}
+
+// deliver an event to each endpoint, failures are logged
+func (w *WebhookNotifier) deliver(item webhookItem) {
+
+	for _, endpoint := range w.endpoints {
+		// signed at delivery so the timestamp reflects when it was sent
+		timestamp := fmt.Sprintf("%d", time.Now().Unix())
+		headers := map[string]string{
+			webhookEventHeader:     item.event,
+			webhookTimestampHeader: timestamp,
+			webhookSignatureHeader: signWebhookPayload(w.secret, timestamp, item.payload),
+		}
+
+		_, err := httpPostWithHeaders(item.workerId, endpoint, w.client, "", headers, item.payload)
+		if err != nil {
+			log.Printf("[worker %d] ERROR: delivering %s event to %s (%s)", item.workerId, item.event, endpoint, err.Error())
+		}
+	}
+}
+
+// sign the payload, the signature covers the timestamp so receivers can reject replayed requests. Receivers
+// calculate the HMAC-SHA256 of "<timestamp>.<body>" using the shared secret and compare
+func signWebhookPayload(secret []byte, timestamp string, payload []byte) string {
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return fmt.Sprintf("sha256=%s", hex.EncodeToString(mac.Sum(nil)))
+}
+
+//
+// end of file
+//
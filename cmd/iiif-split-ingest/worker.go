@@ -1,10 +1,11 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"path"
 	"time"
 
 	"github.com/uvalib/uva-aws-s3-sdk/uva-s3"
@@ -16,129 +17,311 @@ type Notify struct {
 	SourceBucket  string               // the bucket name
 	BucketKey     string               // the bucket key (file name)
 	ExpectedSize  int64                // the expected size of the object
+	ETag          string               // the object ETag (if known)
 	ReceiptHandle awssqs.ReceiptHandle // the inbound message receipt handle (so we can delete it)
+	Message       *InboundMessage      // the inbound message this object was part of
+	LocalFile     string               // the local file name (when watching a directory rather than a queue)
 }
 
-func worker(workerId int, config ServiceConfig, sqsSvc awssqs.AWS_SQS, s3Svc uva_s3.UvaS3, queue awssqs.QueueHandle, notifies <-chan Notify) {
+// JobSummary - the outcome of processing a single inbound file
+type JobSummary struct {
+	Source    string        // the source bucket/key or local file
+	Id        string        // the item id
+	PageCount int           // the number of pages converted
+	Outputs   []string      // the output file locations
+	Manifest  string        // the manifest file (if one was created)
+	Duration  time.Duration // the total processing time
+	Attempts  int           // the number of attempts made
+}
+
+// process notifications until the channel is closed. New work is not started once stopCtx is cancelled and
+// in-flight work is abandoned once jobCtx is cancelled
+func worker(stopCtx context.Context, jobCtx context.Context, workerId int, config ServiceConfig, sqsSvc awssqs.AWS_SQS, s3Svc uva_s3.UvaS3, queue awssqs.QueueHandle, deadQueue awssqs.QueueHandle, events *EventPublisher, webhooks *WebhookNotifier, notifies <-chan Notify) {
+
+	for notify := range notifies {
 
-	var notify Notify
-	for {
-		// wait for an inbound file
-		notify = <-notifies
+		// we are shutting down, leave anything we have not started for redelivery
+		if stopCtx.Err() != nil {
+			log.Printf("[worker %d] INFO: shutting down, not processing %s", workerId, notify.BucketKey)
+			notify.Message.abandon()
+			continue
+		}
 
 		start := time.Now()
 		log.Printf("[worker %d] INFO: processing %s", workerId, notify.BucketKey)
+		webhooks.notify(workerId, newStartedEvent(notify, start))
 
-		// validate the inbound file naming convention
-		//err := validateInputName(workerId, notify.BucketKey)
-		//if err != nil {
-		//	log.Printf("[worker %d] ERROR: input name %s is invalid (%s)", workerId, notify.BucketKey, err.Error())
-		//	continue
-		//}
+		summary, err := processWithRetries(stopCtx, jobCtx, workerId, config, s3Svc, notify)
 
-		// create the working directory
-		workDir, err := makeWorkDir(workerId, config.LocalWorkDir)
-		if err != nil {
+		// the job was cancelled during shutdown, leave it for redelivery
+		if jobCtx.Err() != nil || errors.Is(err, ErrRetryAbandoned) == true {
+			log.Printf("[worker %d] WARNING: processing %s cancelled", workerId, notify.BucketKey)
+			notify.Message.abandon()
 			continue
 		}
 
-		// download the file from S3 to the local work directory
-		downloadedName, err := downloadS3File(workerId, workDir, s3Svc, notify.SourceBucket, notify.BucketKey)
-		if err != nil {
+		// a duplicate notification for an object another job is processing, that job reports the outcome. The
+		// message comes back once its visibility expires in case that job does not finish
+		if errors.Is(err, ErrJobInProgress) == true {
+			log.Printf("[worker %d] INFO: %s is being processed by another job, ignoring this notification", workerId, notify.BucketKey)
+			notify.Message.skip()
 			continue
 		}
 
-		// the list of files to convert
-		var convertFiles = make([]string, 0)
-		// the list of the target files
-		var targetFiles = make([]string, 0)
+		// let anyone downstream know the outcome
+		completion := newCompletionEvent(notify, summary, start, err)
+		_ = events.publish(workerId, completion)
+		webhooks.notify(workerId, completion)
 
-		// are we splitting the inbound file before converting it
-		if len(config.SplitBinary) != 0 {
-			convertFiles, err = splitFile(workerId, config, downloadedName)
-			if err != nil {
-				continue
+		switch {
+
+		case len(notify.LocalFile) != 0:
+			// local files are moved out of the watch directory
+			_ = finishLocalFile(workerId, config, notify.LocalFile, err)
+
+		default:
+			// failed objects are quarantined, if that is possible they no longer hold up the message
+			success := err == nil
+			if success == false {
+				quarantined, e := quarantineObject(workerId, config, sqsSvc, deadQueue, notify, err.Error())
+				success = quarantined == true && e == nil
+			}
+
+			// the inbound message is deleted once all of its objects are accounted for
+			if notify.Message.complete(success) == true {
+				_ = deleteMessage(workerId, sqsSvc, queue, notify.ReceiptHandle)
 			}
-		} else {
-			convertFiles = append(convertFiles, downloadedName)
 		}
 
-		// for every file that needs to be converted
-		for _, inputName := range convertFiles {
+		duration := time.Since(start)
+		log.Printf("[worker %d] INFO: processing %s/%s complete in %0.2f seconds",
+			workerId, notify.SourceBucket, notify.BucketKey, duration.Seconds())
+	}
 
-			// generate all the needed file names
-			convertedName, targetName := generateImageFilenames(workerId, config, downloadedName, inputName)
+	log.Printf("[worker %d] INFO: terminating", workerId)
+}
 
-			// convert the file
-			err = convertFile(workerId, config, inputName, convertedName)
-			if err != nil {
-				break
-			}
+// process a single inbound file, returns a summary of what was done and an error if processing was not successful
+func processFile(ctx context.Context, workerId int, config ServiceConfig, s3Svc uva_s3.UvaS3, notify Notify) (*JobSummary, error) {
 
-			// if we are outputting to a local filesystem
-			if len(config.OutputFSRoot) != 0 {
-				// create the target directory tree
-				err = createDir(workerId, path.Dir(targetName))
-				if err != nil {
-					break
-				}
+	start := time.Now()
+	summary := &JobSummary{Source: fmt.Sprintf("%s/%s", notify.SourceBucket, notify.BucketKey), Outputs: make([]string, 0)}
+	defer func() {
+		summary.Duration = time.Since(start)
+	}()
 
-				// copy the file to the correct location
-				err = copyFile(workerId, convertedName, targetName)
-				if err != nil {
-					break
-				}
-				// and save the output file in case we need to make a manifest
-				targetFiles = append(targetFiles, targetName)
-			} else {
-				// do we have a bucket root defined
-				f := targetName
-				if len(config.OutputBucketRoot) != 0 {
-					f = fmt.Sprintf("%s/%s", config.OutputBucketRoot, f)
-				}
-				o := uva_s3.NewUvaS3Object(config.OutputBucket, f)
-				err := s3Svc.PutFromFile(o, convertedName)
-				if err != nil {
-					break
-				}
-				// and save the output file in case we need to make a manifest
-				targetFiles = append(targetFiles, convertedName)
-			}
+	// validate the inbound file naming convention
+	//err := validateInputName(workerId, notify.BucketKey)
+	//if err != nil {
+	//	log.Printf("[worker %d] ERROR: input name %s is invalid (%s)", workerId, notify.BucketKey, err.Error())
+	//	return err
+	//}
+
+	// a group marker stands for all the page objects alongside it
+	var err error
+	var groupPages []BucketObject
+	grouped := len(notify.LocalFile) == 0 && isGroupMarker(config, notify.BucketKey) == true
+	if grouped == true {
+		groupPages, err = listGroupPages(workerId, config, notify.SourceBucket, notify.BucketKey)
+		if err != nil {
+			return summary, err
 		}
+		notify.ExpectedSize = groupSize(groupPages)
+	}
 
-		// if everything went well
-		if err == nil {
+	// make sure we have the disk space we need before we start
+	reservation, err := admitJob(ctx, workerId, config, notify.ExpectedSize)
+	if err != nil {
+		return summary, err
+	}
+	defer reservation.release()
 
-			// should we create a manifest for the processed file(s)
-			if len(config.ManifestTemplateName) != 0 {
-				log.Printf("[worker %d] DEBUG: creating manifest", workerId)
-				e := createManifest(workerId, config, downloadedName, targetFiles)
-				if e != nil {
-					log.Printf("[worker %d] ERROR: creating manifest (%s)", workerId, e.Error())
-				}
-			} else {
-				log.Printf("[worker %d] DEBUG: no manifest required", workerId)
-			}
+	// the journal is keyed by the object ETag, get it if the notification did not include it
+	if len(config.JournalDir) != 0 && len(notify.LocalFile) == 0 && len(notify.ETag) == 0 {
+		notify.ETag = getS3ETag(workerId, notify.SourceBucket, notify.BucketKey)
+	}
 
-			// should we delete the bucket contents
-			if config.DeleteSource == true {
-				_ = deleteS3File(workerId, s3Svc, notify.SourceBucket, notify.BucketKey)
-			}
+	// the janitor must leave our work directory alone. A journaled job resumes in an existing directory so it
+	// is claimed before the journal is loaded
+	key := jobKey(notify)
+	if len(config.JournalDir) != 0 {
+		resumeDir := journalWorkDir(config, key)
+		claimWorkDir(resumeDir)
+		defer releaseWorkDir(resumeDir)
+	}
 
-			// delete the inbound message
-			_ = deleteMessage(workerId, sqsSvc, queue, notify.ReceiptHandle)
+	// the journal for this job (nil if we are not journaling)
+	journal, err := loadJournal(workerId, config, key)
+	if err != nil {
+		return summary, err
+	}
+	defer journal.unlock()
+
+	// create the working directory, journaled jobs use the same one each time
+	var workDir string
+	if journal != nil {
+		workDir = journal.WorkDir
+	} else {
+		workDir, err = makeWorkDir(workerId, config.LocalWorkDir)
+		if err != nil {
+			return summary, err
 		}
+	}
+
+	// the space we use in the work directory is taken from our reservation
+	reservation.track(workDir)
 
-		// cleanup the work directory (does not matter if we failed or not)
+	// and claim the directory we are actually using
+	claimWorkDir(workDir)
+	defer releaseWorkDir(workDir)
+
+	// cleanup the work directory, journaled jobs keep it until they complete so they can be resumed
+	succeeded := false
+	defer func() {
+		if journal != nil && succeeded == false {
+			return
+		}
 		log.Printf("[worker %d] DEBUG: cleaning up %s", workerId, workDir)
 		_ = os.RemoveAll(workDir)
+	}()
 
-		duration := time.Since(start)
-		log.Printf("[worker %d] INFO: processing %s/%s complete in %0.2f seconds",
-			workerId, notify.SourceBucket, notify.BucketKey, duration.Seconds())
+	// the converted pages and the files written to the output filesystem
+	var convertedPages = make([]PageResult, 0)
+	var outputFiles = make([]string, 0)
+
+	// if we are cancelled, remove any files we have already written to the output filesystem (unless
+	// we are journaling, in which case they will be used when the job is resumed)
+	defer func() {
+		if ctx.Err() != nil && len(config.OutputFSRoot) != 0 && journal == nil {
+			removeOutputFiles(workerId, outputFiles)
+		}
+	}()
+
+	// download the file from S3 (or copy the local file) to the local work directory
+	downloadedName := journal.downloaded()
+	if len(downloadedName) == 0 {
+		if len(notify.LocalFile) != 0 {
+			downloadedName, err = copyLocalFile(workerId, workDir, notify.LocalFile)
+		} else if grouped == true {
+			// the pages are downloaded when we split, the group has no single file so use a name that gives the id
+			downloadedName = fmt.Sprintf("%s/%s%s", workDir, groupId(notify.BucketKey), groupFileSuffix)
+		} else {
+			downloadedName, err = downloadS3File(workerId, config, workDir, s3Svc, notify.SourceBucket, notify.BucketKey, notify.ExpectedSize)
+		}
+		if err != nil {
+			return summary, err
+		}
+		journal.recordDownload(downloadedName)
+	}
+	summary.Id = idFromFilename(downloadedName)
+
+	if ctx.Err() != nil {
+		return summary, ctx.Err()
+	}
+
+	// the list of files to convert
+	convertFiles := journal.pages()
+	if convertFiles == nil && grouped == true {
+		// a group is already split, download the pages in page order
+		convertFiles, err = downloadGroupPages(ctx, workerId, config, workDir, s3Svc, notify.SourceBucket, summary.Id, groupPages)
+		if err != nil {
+			return summary, err
+		}
+		journal.recordSplit(convertFiles)
+	}
+	if convertFiles == nil {
+		// split the inbound file using the splitter appropriate for its type
+		splitter, err := selectSplitter(workerId, config, downloadedName)
+		if err != nil {
+			return summary, err
+		}
+		convertFiles, err = splitter.Split(ctx, workerId, config, downloadedName)
+		if err != nil {
+			return summary, err
+		}
+
+		// the page order is carried through to the outputs and the manifest
+		convertFiles, err = orderPages(workerId, config, splitter, convertFiles)
+		if err != nil {
+			return summary, err
+		}
+		journal.recordSplit(convertFiles)
+	}
+	summary.PageCount = len(convertFiles)
+
+	// the conversion stage as a whole may have a deadline
+	convertCtx := ctx
+	if config.ConvertTimeout != 0 {
+		var cancelConvert context.CancelFunc
+		convertCtx, cancelConvert = context.WithTimeout(ctx, time.Duration(config.ConvertTimeout)*time.Second)
+		defer cancelConvert()
 	}
 
-	// should never get here
+	// convert every page and write it to the output location
+	results, err := convertPages(convertCtx, workerId, config, s3Svc, journal, downloadedName, convertFiles)
+	for _, r := range results {
+		if len(r.TargetFile) != 0 {
+			// save the page in case we need to make a manifest
+			convertedPages = append(convertedPages, r)
+			outputFiles = append(outputFiles, r.Output)
+			summary.Outputs = append(summary.Outputs, r.Output)
+			for _, name := range renditionNames(config) {
+				if rendition, ok := r.Renditions[name]; ok == true {
+					outputFiles = append(outputFiles, rendition.Output)
+					summary.Outputs = append(summary.Outputs, rendition.Output)
+				}
+			}
+		}
+	}
+	if err != nil {
+		return summary, err
+	}
+
+	if ctx.Err() != nil {
+		return summary, ctx.Err()
+	}
+
+	// record the checksums of everything we output so it can be verified later
+	sidecars, err := writeFixitySidecars(workerId, config, s3Svc, summary.Id, convertedPages)
+	outputFiles = append(outputFiles, sidecars...)
+	if err != nil {
+		return summary, err
+	}
+
+	// should we create a manifest for the processed file(s)
+	if len(config.ManifestTemplateName) != 0 {
+		if journal.manifestDone() == true {
+			log.Printf("[worker %d] DEBUG: manifest already created", workerId)
+			summary.Manifest = generateManifestFilename(config, downloadedName)
+		} else {
+			log.Printf("[worker %d] DEBUG: creating manifest", workerId)
+			// the job has not succeeded without its manifest, the journal means a retry only redoes this
+			err = createManifest(workerId, config, downloadedName, convertedPages)
+			if err != nil {
+				log.Printf("[worker %d] ERROR: creating manifest (%s)", workerId, err.Error())
+				return summary, fmt.Errorf("creating manifest: %w", err)
+			}
+			summary.Manifest = generateManifestFilename(config, downloadedName)
+			journal.recordManifest()
+		}
+	} else {
+		log.Printf("[worker %d] DEBUG: no manifest required", workerId)
+	}
+
+	// should we delete the bucket contents
+	if config.DeleteSource == true && len(notify.LocalFile) == 0 {
+		if grouped == true {
+			deleteGroup(workerId, s3Svc, notify.SourceBucket, notify.BucketKey, groupPages)
+		} else {
+			_ = deleteS3File(workerId, s3Svc, notify.SourceBucket, notify.BucketKey)
+		}
+	}
+
+	// the job is complete, we no longer need the journal
+	journal.remove()
+	succeeded = true
+
+	return summary, nil
 }
 
 func deleteMessage(workerId int, aws awssqs.AWS_SQS, queue awssqs.QueueHandle, receiptHandle awssqs.ReceiptHandle) error {
@@ -3,13 +3,13 @@ module github.com/uvalib/iiif-split-ingest
 go 1.18
 
 require (
+	github.com/aws/aws-sdk-go v1.55.8
 	github.com/barasher/go-exiftool v1.10.0
 	github.com/uvalib/uva-aws-s3-sdk/uva-s3 v0.0.0-20240202155653-277e11cf83e3
 	github.com/uvalib/virgo4-sqs-sdk/awssqs v0.0.0-20240403123433-2102b063dbb8
 )
 
 require (
-	github.com/aws/aws-sdk-go v1.55.8 // indirect
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
 )